@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	logging "github.com/ipfs/go-log"
+	experiment "github.com/libp2p/dht-tracer1/lib/experiment"
+)
+
+var Usage = `SYNOPSIS
+    dhtexp - run declarative dht experiments
+
+USAGE
+    dhtexp [<opts>...] <spec-file>
+
+OPTIONS
+    -h, --help        show usage
+    --out <dir>       directory to write results to
+                      (default: results/<name>-<timestamp>)
+    --debug           enable debug logging
+
+REGRESSION CHECKS
+    --baseline <file>       metrics.json of an earlier run, to check the
+                            spec's relative assertions against
+    --save-baseline <file>  write this run's metrics to <file>
+    --metrics <file>        check this metrics.json instead of running
+                            the experiment
+
+    dhtexp exits with status 1 if any assertion fails.
+
+SPEC
+    Specs are JSON files. An experiment starts a local network,
+    optionally populates it with records, starts a tracer per
+    configuration, runs the same queries through each tracer, and
+    writes metrics and traces to the output directory.
+
+    {
+      "name": "alpha-sweep",
+      "network": {
+        "size": 200,
+        "transport": "mock",          // mock (default), tcp, quic
+        "topology": "random",         // bootstrappers (default), random, ring
+        "degree": 4,
+        "latency": {"model": "uniform", "min": "10ms", "max": "150ms"},
+        "params": "bucket-size=20",   // dht params of the network nodes
+        "adversaries": [{"kind": "dead", "count": 20},
+                        {"kind": "refuse", "count": 10}]
+      },
+      "tracers": [
+        {"name": "alpha3", "params": "alpha=3"},
+        {"name": "alpha10", "params": "alpha=10,beta=5"}
+      ],
+      "workload": {"values": 50, "providers": 50, "dist": "zipf"},
+      "queries": {"find-peer": 20, "get-value": 20, "get-providers": 20,
+                  "lines": ["get-value /v/foo"]},
+      "metrics": ["success", "latency", "messages", "recall", "hops"],
+      "rounds": 3,
+      "settle": "10s",
+      "timeout": "1m",
+      "otlp": "http://localhost:4318/v1/traces",
+      "assertions": [
+        {"metric": "*.find-peer.latency-ms.p95", "max-increase": 0.1},
+        {"metric": "*.get-value.recall.mean", "min": 0.9}
+      ]
+    }
+
+    Metrics are named <tracer>.<command|all>.<metric>[.<stat>], e.g.
+    alpha3.find-peer.latency-ms.p95. In assertions, * matches any
+    part of a name. Assertions bound metrics with min and max, or
+    relative to --baseline with max-increase and max-decrease
+    (fractions of the baseline value). Without --baseline, relative
+    bounds are skipped.
+
+    (comments are for illustration, and are not valid in spec files.)
+
+EXAMPLES
+    dhtexp --out results/alpha alpha-sweep.json
+
+    # save a baseline, then check a later run against it
+    dhtexp --save-baseline baseline.json alpha-sweep.json
+    dhtexp --baseline baseline.json alpha-sweep.json
+`
+
+type Opts struct {
+	OutDir       string
+	Debug        bool
+	Baseline     string
+	SaveBaseline string
+	Metrics      string
+}
+
+var errChecksFailed = errors.New("assertions failed")
+
+func parseOpts() (Opts, []string) {
+	var o Opts
+	flag.StringVar(&o.OutDir, "out", "", "results directory")
+	flag.BoolVar(&o.Debug, "debug", false, "enable debug logging")
+	flag.StringVar(&o.Baseline, "baseline", "", "baseline metrics file")
+	flag.StringVar(&o.SaveBaseline, "save-baseline", "", "file to save metrics to")
+	flag.StringVar(&o.Metrics, "metrics", "", "metrics file to check")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, Usage)
+	}
+	flag.Parse()
+	return o, flag.Args()
+}
+
+func errMain(opts Opts, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: dhtexp [<opts>...] <spec-file>")
+	}
+
+	if opts.Debug {
+		logging.SetLogLevel("tracedhtnode", "debug")
+	}
+
+	spec, err := experiment.LoadSpec(args[0])
+	if err != nil {
+		return err
+	}
+
+	var m experiment.Metrics
+	if opts.Metrics != "" {
+		m, err = experiment.LoadMetrics(opts.Metrics)
+	} else {
+		m, err = runExperiment(spec, opts.OutDir)
+	}
+	if err != nil {
+		return err
+	}
+
+	if opts.SaveBaseline != "" {
+		if err := m.WriteFile(opts.SaveBaseline); err != nil {
+			return err
+		}
+		fmt.Println("saved baseline to:", opts.SaveBaseline)
+	}
+
+	return checkAssertions(spec, m, opts.Baseline)
+}
+
+func runExperiment(spec *experiment.Spec, out string) (experiment.Metrics, error) {
+	if out == "" {
+		ts := time.Now().Format("20060102-150405")
+		out = filepath.Join("results", spec.Name+"-"+ts)
+	}
+
+	m, err := experiment.Run(context.Background(), spec, out, os.Stdout)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("wrote results to:", out)
+	return m, nil
+}
+
+func checkAssertions(spec *experiment.Spec, m experiment.Metrics, baselineFile string) error {
+	if len(spec.Assertions) < 1 {
+		return nil
+	}
+
+	var baseline experiment.Metrics
+	if baselineFile != "" {
+		var err error
+		baseline, err = experiment.LoadMetrics(baselineFile)
+		if err != nil {
+			return err
+		}
+	}
+
+	fmt.Println("checking assertions...")
+	if experiment.PrintChecks(os.Stdout, experiment.Checks(m, baseline, spec.Assertions)) > 0 {
+		return errChecksFailed
+	}
+	return nil
+}
+
+func main() {
+	opts, args := parseOpts()
+	err := errMain(opts, args)
+	if err == errChecksFailed {
+		os.Exit(1)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(-1)
+	}
+}
@@ -0,0 +1,286 @@
+package dhttracer
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// APIPrefix is where the versioned json api is mounted. See openapi.json.
+const APIPrefix = "/api/v1/"
+
+//go:embed openapi.json
+var openAPISpec []byte
+
+// API error codes: the ErrorClass of a failed query, or one of these.
+const (
+	APIErrBadRequest       = "bad-request"
+	APIErrNoSuchEndpoint   = "no-such-endpoint"
+	APIErrMethodNotAllowed = "method-not-allowed"
+	APIErrForbidden        = "forbidden"
+)
+
+var apiErrStatus = map[string]int{
+	APIErrBadRequest:       http.StatusBadRequest,
+	APIErrNoSuchEndpoint:   http.StatusNotFound,
+	APIErrMethodNotAllowed: http.StatusMethodNotAllowed,
+	APIErrForbidden:        http.StatusForbidden,
+	ErrClassNotFound:       http.StatusNotFound,
+	ErrClassTimeout:        http.StatusGatewayTimeout,
+	ErrClassCanceled:       http.StatusServiceUnavailable,
+	ErrClassNoPeers:        http.StatusServiceUnavailable,
+	ErrClassOther:          http.StatusBadGateway,
+}
+
+// APIError is the body of every failed api request.
+type APIError struct {
+	Error struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		QueryID string `json:"query_id,omitempty"`
+	} `json:"error"`
+}
+
+// APIResult is the body of every successful query.
+type APIResult struct {
+	QueryID    string      `json:"query_id"`
+	Command    string      `json:"command"`
+	Key        string      `json:"key"`
+	DurationMs float64     `json:"duration_ms"`
+	Result     interface{} `json:"result,omitempty"`
+}
+
+// apiRequest is the union of the command request bodies. Each command
+// checks for the fields it needs, and the QueryOpts it takes.
+type apiRequest struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+	CID   string `json:"cid"`
+	Peer  string `json:"peer"`
+	Path  string `json:"path"` // of ipns-publish
+	Name  string `json:"name"` // of ipns-resolve
+
+	Quorum       int    `json:"quorum"`
+	Offline      bool   `json:"offline"`
+	Timeout      string `json:"timeout"` // a duration, e.g. "10s"
+	MaxProviders int    `json:"max_providers"`
+	Lifetime     string `json:"lifetime"` // a duration
+	TTL          string `json:"ttl"`      // a duration
+
+	KeyEncoding   string `json:"key_encoding"`
+	ValueEncoding string `json:"value_encoding"`
+	Output        string `json:"output"`
+}
+
+type apiAddrInfo struct {
+	ID    string   `json:"id"`
+	Addrs []string `json:"addrs"`
+}
+
+func toAPIAddrInfo(ai peer.AddrInfo) apiAddrInfo {
+	a := apiAddrInfo{ID: ai.ID.String(), Addrs: []string{}}
+	for _, ma := range ai.Addrs {
+		a.Addrs = append(a.Addrs, ma.String())
+	}
+	return a
+}
+
+// apiQuery builds the Query of a command from its request body.
+func apiQuery(cmd string, r apiRequest) (Query, error) {
+	need := func(name, v string) error {
+		if v == "" {
+			return fmt.Errorf("%s needs %q", cmd, name)
+		}
+		return nil
+	}
+
+	q := Query{Cmd: cmd, Opts: QueryOpts{
+		Quorum:        r.Quorum,
+		Offline:       r.Offline,
+		MaxProviders:  r.MaxProviders,
+		KeyEncoding:   r.KeyEncoding,
+		ValueEncoding: r.ValueEncoding,
+		Output:        r.Output,
+	}}
+	for _, d := range [][2]string{{"timeout", r.Timeout}, {"lifetime", r.Lifetime}, {"ttl", r.TTL}} {
+		if d[1] != "" {
+			if err := q.Opts.Set(d[0], d[1]); err != nil {
+				return q, err
+			}
+		}
+	}
+
+	var err error
+	switch cmd {
+	case CmdPutValue:
+		err = need("key", r.Key)
+		q.Key, q.Args = r.Key, []string{r.Value}
+	case CmdGetValue, CmdGetClosestPeers, CmdSearchValue:
+		err = need("key", r.Key)
+		q.Key = r.Key
+	case CmdAddProvider, CmdGetProviders:
+		err = need("cid", r.CID)
+		q.Key = r.CID
+	case CmdFindPeer, CmdPing:
+		err = need("peer", r.Peer)
+		q.Key = r.Peer
+	case CmdIPNSPublish:
+		if err = need("key", r.Key); err == nil {
+			err = need("path", r.Path)
+		}
+		q.Key, q.Args = r.Key, []string{r.Path}
+	case CmdIPNSResolve:
+		err = need("name", r.Name)
+		q.Key = r.Name
+	default:
+		err = fmt.Errorf("unknown command: %v", cmd)
+	}
+	return q, err
+}
+
+// apiRecord is a value in json, in the encoding asked for, unless it is
+// binary. See jsonValue.
+func apiRecord(enc string, v []byte) map[string]interface{} {
+	val, enc := jsonValue(enc, v)
+	return map[string]interface{}{"value": val, "encoding": enc, "size": len(v)}
+}
+
+// apiValue converts a Result.Value to its json form. Values are encoded in
+// enc.
+func apiValue(enc string, v interface{}) interface{} {
+	switch v := v.(type) {
+	case []byte:
+		return apiRecord(enc, v)
+	case []peer.AddrInfo:
+		ps := []apiAddrInfo{}
+		for _, ai := range v {
+			ps = append(ps, toAPIAddrInfo(ai))
+		}
+		return map[string]interface{}{"providers": ps}
+	case peer.AddrInfo:
+		return map[string]interface{}{"peer": toAPIAddrInfo(v)}
+	case time.Duration:
+		return map[string]float64{"rtt_ms": float64(v) / float64(time.Millisecond)}
+	case []ClosestPeer:
+		ps := []map[string]interface{}{}
+		for _, cp := range v {
+			ps = append(ps, map[string]interface{}{
+				"id":              cp.Peer.String(),
+				"xor_distance":    cp.XORDistance,
+				"bucket_distance": cp.BucketDistance,
+			})
+		}
+		return map[string]interface{}{"peers": ps}
+	case []SearchRecord:
+		rs := []map[string]interface{}{}
+		for _, r := range v {
+			rec := apiRecord(enc, r.Value)
+			rec["likely_supplier"] = r.LikelySupplier.String()
+			rec["elapsed_ms"] = float64(r.Elapsed) / float64(time.Millisecond)
+			rs = append(rs, rec)
+		}
+		return map[string]interface{}{"records": rs}
+	case IPNSRecord:
+		return map[string]interface{}{
+			"name":   v.Name.String(),
+			"value":  v.Value,
+			"seq":    v.Seq,
+			"eol":    v.EOL.UTC().Format(time.RFC3339Nano),
+			"ttl_ms": float64(v.TTL) / float64(time.Millisecond),
+		}
+	default:
+		return nil
+	}
+}
+
+func writeJSON(res http.ResponseWriter, status int, v interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(v)
+}
+
+func writeAPIError(res http.ResponseWriter, code string, err error, queryID string) {
+	var e APIError
+	e.Error.Code = code
+	e.Error.Message = err.Error()
+	e.Error.QueryID = queryID
+	writeJSON(res, apiErrStatus[code], e)
+}
+
+// handleAPI serves the json api:
+//
+//	POST /api/v1/<command>   run a query, e.g. put-value {"key", "value"}
+//	GET  /api/v1/version
+//	GET  /api/v1/openapi.json
+func (s *HTTPServer) handleAPI(res http.ResponseWriter, req *http.Request) {
+	endpoint := strings.TrimPrefix(req.URL.Path, APIPrefix)
+	fmt.Fprintf(os.Stderr, "%s %s\n", req.Method, req.URL.Path)
+
+	switch {
+	case endpoint == "openapi.json":
+		res.Header().Set("Content-Type", "application/json")
+		res.Write(openAPISpec)
+		return
+	case endpoint == "version":
+		writeJSON(res, http.StatusOK, map[string]string{"version": Version})
+		return
+	case !cmdInGroup(endpoint, QueryCmds):
+		writeAPIError(res, APIErrNoSuchEndpoint, fmt.Errorf("no such endpoint: %v", req.URL.Path), "")
+		return
+	case req.Method != http.MethodPost:
+		res.Header().Set("Allow", http.MethodPost)
+		writeAPIError(res, APIErrMethodNotAllowed, fmt.Errorf("%v takes POST", req.URL.Path), "")
+		return
+	}
+
+	if err := s.checkCmd(endpoint); err != nil {
+		writeAPIError(res, APIErrForbidden, err, "")
+		return
+	}
+
+	var body apiRequest
+	dec := json.NewDecoder(req.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&body); err != nil {
+		writeAPIError(res, APIErrBadRequest, fmt.Errorf("request body: %w", err), "")
+		return
+	}
+	q, err := apiQuery(endpoint, body)
+	if err == nil {
+		err = q.Opts.Check(q.Cmd)
+	}
+	if err != nil {
+		writeAPIError(res, APIErrBadRequest, err, "")
+		return
+	}
+
+	r, err := s.Tracer.Run(req.Context(), q)
+	switch {
+	case errors.Is(err, ErrStopping):
+		writeAPIError(res, ErrorClass(err), err, "")
+		return
+	case r == nil: // the query did not run: bad key, cid or peer id
+		writeAPIError(res, APIErrBadRequest, err, "")
+		return
+	}
+	res.Header().Set("X-Trace-Id", r.Trace.ID)
+	if err != nil {
+		writeAPIError(res, ErrorClass(err), err, r.Trace.ID)
+		return
+	}
+
+	writeJSON(res, http.StatusOK, APIResult{
+		QueryID:    r.Trace.ID,
+		Command:    q.Cmd,
+		Key:        q.Key,
+		DurationMs: float64(r.Trace.Duration()) / float64(time.Millisecond),
+		Result:     apiValue(q.Opts.Output, r.Value),
+	})
+}
@@ -0,0 +1,64 @@
+package dhttracer
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// TokenCookie holds the auth token of browsers, which cannot set headers on
+// websockets. It is set when a page is opened with ?token=<token>.
+const TokenCookie = "tracedht_token"
+
+// ServeHTTP checks the request's auth token, if the server has one, then
+// serves it from Mux. The token is taken from an "Authorization: Bearer"
+// header, a ?token= parameter, or the TokenCookie.
+func (s *HTTPServer) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	if s.Token != "" {
+		token, fromQuery := requestToken(req)
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+			fmt.Fprintf(os.Stderr, "unauthorized %s %s from %s\n", req.Method, req.URL.Path, req.RemoteAddr)
+			res.Header().Set("WWW-Authenticate", `Bearer realm="tracedht"`)
+			http.Error(res, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if fromQuery {
+			// handlers refuse unknown parameters.
+			q := req.URL.Query()
+			q.Del("token")
+			req.URL.RawQuery = q.Encode()
+			http.SetCookie(res, &http.Cookie{
+				Name:     TokenCookie,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   req.TLS != nil,
+				SameSite: http.SameSiteStrictMode,
+			})
+		}
+	}
+	s.Mux.ServeHTTP(res, req)
+}
+
+func requestToken(req *http.Request) (token string, fromQuery bool) {
+	if h := req.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		return strings.TrimPrefix(h, "Bearer "), false
+	}
+	if t := req.URL.Query().Get("token"); t != "" {
+		return t, true
+	}
+	if c, err := req.Cookie(TokenCookie); err == nil {
+		return c.Value, false
+	}
+	return "", false
+}
+
+// checkCmd returns an error if the server may not run cmd.
+func (s *HTTPServer) checkCmd(cmd string) error {
+	if s.ReadOnly && cmdInGroup(cmd, MutatingCmds) {
+		return fmt.Errorf("%v is disabled: the server is read-only", cmd)
+	}
+	return nil
+}
@@ -1,3 +0,0 @@
-module github.com/libp2p/dht-tracer1/datafmts
-
-go 1.24
@@ -6,7 +6,14 @@ type QueryRunnerTable struct {
 	Queries []QueryRow
 }
 
+// QuerySection is a query, and its peers.
+type QuerySection struct {
+	Query       QueryRow
+	PeerQueries []QueryPeerRow
+}
+
 type QueryRow struct {
+	QueryID     string
 	Key         string
 	Type        string
 	RunnerState QueryRunnerState
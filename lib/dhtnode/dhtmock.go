@@ -0,0 +1,82 @@
+package dhtnode
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	host "github.com/libp2p/go-libp2p-core/host"
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+)
+
+const (
+	LatencyNone     = "none"
+	LatencyConstant = "constant"
+	LatencyUniform  = "uniform"
+)
+
+// LatencyModel picks the latency of a new link between two peers.
+type LatencyModel func() time.Duration
+
+// NewLatencyModel returns a LatencyNone, LatencyConstant (min) or
+// LatencyUniform (between min and max) model.
+func NewLatencyModel(model string, min, max time.Duration) (LatencyModel, error) {
+	switch model {
+	case LatencyNone, "":
+		return func() time.Duration { return 0 }, nil
+	case LatencyConstant:
+		return func() time.Duration { return min }, nil
+	case LatencyUniform:
+		if max < min {
+			return nil, fmt.Errorf("uniform latency: max %v < min %v", max, min)
+		}
+		return func() time.Duration {
+			return min + time.Duration(rand.Int63n(int64(max-min)+1))
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown latency model: %v", model)
+	}
+}
+
+// MockNet runs hosts on an in-memory libp2p network, where every pair of
+// hosts is linked with a latency picked by a LatencyModel. This keeps large
+// networks off the fd limit, and makes latency part of the experiment.
+type MockNet struct {
+	Net     mocknet.Mocknet
+	Latency LatencyModel
+
+	mu sync.Mutex
+}
+
+func NewMockNet(latency LatencyModel) *MockNet {
+	return &MockNet{
+		Net:     mocknet.New(context.Background()),
+		Latency: latency,
+	}
+}
+
+// NewHost adds a host to the mocknet, linked to all existing hosts. Use it
+// as NodeCfg.NewHost.
+func (m *MockNet) NewHost() (host.Host, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, err := m.Net.GenPeer()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range m.Net.Peers() {
+		if p == h.ID() {
+			continue
+		}
+		l, err := m.Net.LinkPeers(h.ID(), p)
+		if err != nil {
+			return nil, err
+		}
+		l.SetOptions(mocknet.LinkOptions{Latency: m.Latency()})
+	}
+	return h, nil
+}
@@ -3,9 +3,12 @@ package dhtnode
 import (
 	"fmt"
 	"io"
+	"math/rand"
+	"strings"
 	"sync"
 
 	peer "github.com/libp2p/go-libp2p-core/peer"
+	kb "github.com/libp2p/go-libp2p-kbucket"
 	ma "github.com/multiformats/go-multiaddr"
 )
 
@@ -57,12 +60,39 @@ func NewNet(numNodes int, cfg NodeCfg) (*Net, error) {
 		// if no addrs given, use first 10 as bootstrappers.
 		// more than 1 is useful to create an unevenly connected network.
 		numForBootstrap := 10
+		if len(net.Nodes) < numForBootstrap {
+			numForBootstrap = len(net.Nodes)
+		}
 		net.BootstrapAddrs = GetAddrInfos(net.Nodes[:numForBootstrap])
 	}
 
 	return net, nil
 }
 
+// Close closes all the nodes, at once.
+func (net *Net) Close() error {
+	var mu sync.Mutex
+	var errs []string
+	var wg sync.WaitGroup
+	for _, n := range net.Nodes {
+		wg.Add(1)
+		go func(n *Node) {
+			defer wg.Done()
+			if err := n.Close(); err != nil {
+				mu.Lock()
+				errs = append(errs, err.Error())
+				mu.Unlock()
+			}
+		}(n)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d nodes: %s", len(errs), len(net.Nodes), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
 func (net *Net) Bootstrap() {
 	log.Debug("bootstrapping network - start")
 
@@ -84,6 +114,83 @@ func (net *Net) Bootstrap() {
 	log.Debug("bootstrapping network - end")
 }
 
+// ClosestPeers returns the ids of the k nodes in the network closest to
+// the kad key, skipping any in exclude. Since the network is fully known,
+// this is the ground truth a lookup for key should converge to.
+func (net *Net) ClosestPeers(key string, k int, exclude ...peer.ID) []peer.ID {
+	skip := map[peer.ID]bool{}
+	for _, p := range exclude {
+		skip[p] = true
+	}
+
+	var ps []peer.ID
+	for _, n := range net.Nodes {
+		if !skip[n.ID()] {
+			ps = append(ps, n.ID())
+		}
+	}
+
+	ps = kb.SortClosestPeers(ps, kb.ConvertKey(key))
+	if len(ps) > k {
+		ps = ps[:k]
+	}
+	return ps
+}
+
+const (
+	TopologyBootstrappers = "bootstrappers"
+	TopologyRandom        = "random"
+	TopologyRing          = "ring"
+)
+
+// BootstrapTopology bootstraps the network with an initial connection
+// graph: TopologyBootstrappers is Bootstrap(), TopologyRandom connects each
+// node to degree random nodes, and TopologyRing to the next degree nodes.
+func (net *Net) BootstrapTopology(topology string, degree int) error {
+	var peersOf func(i int) []*Node
+	switch topology {
+	case TopologyBootstrappers, "":
+		net.Bootstrap()
+		return nil
+	case TopologyRandom:
+		peersOf = func(i int) []*Node {
+			var ps []*Node
+			for _, j := range rand.Perm(len(net.Nodes)) {
+				if j != i && len(ps) < degree {
+					ps = append(ps, net.Nodes[j])
+				}
+			}
+			return ps
+		}
+	case TopologyRing:
+		peersOf = func(i int) []*Node {
+			var ps []*Node
+			for d := 1; d <= degree && d < len(net.Nodes); d++ {
+				ps = append(ps, net.Nodes[(i+d)%len(net.Nodes)])
+			}
+			return ps
+		}
+	default:
+		return fmt.Errorf("unknown topology: %v", topology)
+	}
+
+	log.Debugf("bootstrapping %s network - start", topology)
+	var wg sync.WaitGroup
+	for i, n := range net.Nodes {
+		wg.Add(1)
+		go func(n *Node, ps []*Node) {
+			defer wg.Done()
+			err := BootstrapTo(n, GetAddrInfos(ps))
+			if err != nil {
+				log.Error("failed to bootstrap", n, err)
+			}
+		}(n, peersOf(i))
+	}
+	wg.Wait()
+	log.Debugf("bootstrapping %s network - end", topology)
+	return nil
+}
+
 func GetAddrInfos(nodes []*Node) []*peer.AddrInfo {
 	nodes2 := make([]*peer.AddrInfo, len(nodes))
 	for i, n := range nodes {
@@ -11,13 +11,12 @@ import (
 	levelds "github.com/ipfs/go-ds-leveldb"
 	ipfsconfig "github.com/ipfs/go-ipfs-config"
 
-	// ipns "github.com/ipfs/go-ipns"
 	logging "github.com/ipfs/go-log"
 	libp2p "github.com/libp2p/go-libp2p"
 	host "github.com/libp2p/go-libp2p-core/host"
 	peer "github.com/libp2p/go-libp2p-core/peer"
+	protocol "github.com/libp2p/go-libp2p-core/protocol"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
-	record "github.com/libp2p/go-libp2p-record"
 	ping "github.com/libp2p/go-libp2p/p2p/protocol/ping"
 )
 
@@ -73,8 +72,12 @@ func ParseStrAddrs(addrs []string) ([]*peer.AddrInfo, error) {
 }
 
 type Node struct {
-	Host host.Host
-	DHT  *dht.IpfsDHT
+	Host      host.Host
+	DHT       *dht.IpfsDHT
+	Datastore *levelds.Datastore
+
+	// Protocol is the dht protocol the node speaks, and serves.
+	Protocol protocol.ID
 }
 
 func (n *Node) String() string {
@@ -95,13 +98,29 @@ func (n *Node) AddrInfo() *peer.AddrInfo {
 
 func (n *Node) RoutingTable() io.Reader {
 	buf := bytes.NewBuffer(nil)
-	PrintLatencyTable(buf, n.Host)
+	PrintRoutingTable(buf, n)
 	return buf
 }
 
-func Bootstrap(n *Node, bootstrap []*peer.AddrInfo) error {
-	ctx := context.Background()
+// Close shuts down the node's dht, host and datastore.
+func (n *Node) Close() error {
+	var errs []string
+	if err := n.DHT.Close(); err != nil {
+		errs = append(errs, fmt.Sprintf("dht: %v", err))
+	}
+	if err := n.Host.Close(); err != nil {
+		errs = append(errs, fmt.Sprintf("host: %v", err))
+	}
+	if err := n.Datastore.Close(); err != nil {
+		errs = append(errs, fmt.Sprintf("datastore: %v", err))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("closing %v: %s", n, strings.Join(errs, ", "))
+	}
+	return nil
+}
 
+func Bootstrap(n *Node, bootstrap []*peer.AddrInfo) error {
 	nb := 3 // number to bootstrap to
 	var ais []*peer.AddrInfo
 	for _, i := range rand.Perm(len(bootstrap)) {
@@ -111,6 +130,13 @@ func Bootstrap(n *Node, bootstrap []*peer.AddrInfo) error {
 		}
 	}
 
+	return BootstrapTo(n, ais)
+}
+
+// BootstrapTo connects n to all of ais, and then bootstraps its dht.
+func BootstrapTo(n *Node, ais []*peer.AddrInfo) error {
+	ctx := context.Background()
+
 	log.Debug("bootstrapping", n.ID(), "to", ais)
 
 	for _, ai := range ais {
@@ -158,13 +184,36 @@ func NewNode(cfg NodeCfg) (*Node, error) {
 		return nil, err
 	}
 
-	h, err := libp2p.New(context.Background(), cfg.Libp2pOpts...)
+	var h host.Host
+	if cfg.NewHost != nil {
+		h, err = cfg.NewHost()
+	} else {
+		h, err = libp2p.New(context.Background(), cfg.Libp2pOpts...)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	validator, err := cfg.Validators.validator(h.Peerstore())
+	if err != nil {
+		return nil, err
+	}
 	dhtOpts := []dht.Option{
 		dht.Datastore(ds),
+		dht.Validator(validator),
+	}
+	proto := dht.ProtocolDHT
+	switch {
+	case cfg.ProtocolPrefix != "":
+		dhtOpts = append(dhtOpts, dht.ProtocolPrefix(cfg.ProtocolPrefix))
+		proto = KadProtocol(cfg.ProtocolPrefix)
+	case !publicValidator(validator):
+		// kad-dht only lets /ipfs nodes validate /pk/ and /ipns/ records.
+		// Nodes that validate other namespaces, like /v/, speak the public
+		// dht protocol under their own prefix.
+		dhtOpts = append(dhtOpts,
+			dht.ProtocolPrefix(LocalProtocolPrefix),
+			dht.V1ProtocolOverride(dht.ProtocolDHT))
 	}
 	dhtOpts = append(dhtOpts, cfg.DhtOpts...)
 
@@ -173,15 +222,9 @@ func NewNode(cfg NodeCfg) (*Node, error) {
 		return nil, err
 	}
 
-	d.Validator = record.NamespacedValidator{
-		"pk": record.PublicKeyValidator{},
-		// "ipns": ipns.Validator{KeyBook: h.Peerstore()},
-		"v": blankValidator{},
-	}
-
 	// dont need to store it, only mount it
 	_ = ping.NewPingService(h)
-	n := &Node{h, d}
+	n := &Node{h, d, ds, proto}
 
 	if len(cfg.Bootstrap) > 0 {
 		err = Bootstrap(n, cfg.Bootstrap)
@@ -1,8 +1,14 @@
 package dhtnode
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+
 	libp2p "github.com/libp2p/go-libp2p"
+	host "github.com/libp2p/go-libp2p-core/host"
 	peer "github.com/libp2p/go-libp2p-core/peer"
+	protocol "github.com/libp2p/go-libp2p-core/protocol"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	quic "github.com/libp2p/go-libp2p-quic-transport"
 )
@@ -11,12 +17,22 @@ type NodeCfg struct {
 	Bootstrap  []*peer.AddrInfo
 	Libp2pOpts []libp2p.Option
 	DhtOpts    []dht.Option
+	Validators ValidatorCfg
+
+	// ProtocolPrefix is the dht protocol prefix. Empty for the public
+	// dht's, /ipfs. See LocalProtocolPrefix.
+	ProtocolPrefix protocol.ID
+
+	// NewHost, if set, makes the node's host instead of libp2p.New with
+	// Libp2pOpts. e.g. MockNet.NewHost
+	NewHost func() (host.Host, error)
 }
 
 func DefaultNodeCfg() NodeCfg {
 	return NodeCfg{
 		Bootstrap:  BootstrapAddrs,
 		Libp2pOpts: Libp2pOptionsTCP(),
+		Validators: DefaultValidatorCfg(),
 	}
 }
 
@@ -34,3 +50,115 @@ func Libp2pOptionsTCP() []libp2p.Option {
 		libp2p.DisableRelay(),
 	}
 }
+
+// LocalProtocolPrefix is the dht protocol prefix of local networks. kad-dht
+// refuses bucket sizes other than PublicBucketSize on the public /ipfs dht.
+const LocalProtocolPrefix protocol.ID = "/tracedht"
+
+// KadProtocol is the dht protocol kad-dht speaks under prefix.
+func KadProtocol(prefix protocol.ID) protocol.ID {
+	return prefix + "/kad/1.0.0"
+}
+
+// PublicBucketSize is the only bucket size of the public dht.
+const PublicBucketSize = 20
+
+// DhtParams are the kad-dht tunables worth comparing. Zero values leave the
+// kad-dht default in place.
+type DhtParams struct {
+	Alpha      int    // query concurrency
+	BucketSize int    // k
+	Beta       int    // resiliency: closest peers that must respond to end a lookup
+	Mode       string // auto, auto-server, client or server
+}
+
+var dhtModes = map[string]dht.ModeOpt{
+	"auto":        dht.ModeAuto,
+	"auto-server": dht.ModeAutoServer,
+	"client":      dht.ModeClient,
+	"server":      dht.ModeServer,
+}
+
+// ParseDhtParams parses params in the form "alpha=3,bucket-size=20,beta=3,mode=server".
+func ParseDhtParams(s string) (DhtParams, error) {
+	var p DhtParams
+	for _, kv := range strings.Split(s, ",") {
+		if kv == "" {
+			continue
+		}
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			return p, fmt.Errorf("dht param format: <name>=<value>, got %v", kv)
+		}
+		if err := p.Set(parts[0], parts[1]); err != nil {
+			return p, err
+		}
+	}
+	return p, nil
+}
+
+// Set sets a param by name: alpha, bucket-size, beta or mode.
+func (p *DhtParams) Set(name, val string) error {
+	var err error
+	switch name {
+	case "alpha":
+		p.Alpha, err = strconv.Atoi(val)
+	case "bucket-size":
+		p.BucketSize, err = strconv.Atoi(val)
+	case "beta":
+		p.Beta, err = strconv.Atoi(val)
+	case "mode":
+		if _, ok := dhtModes[val]; !ok {
+			return fmt.Errorf("unknown dht mode: %v", val)
+		}
+		p.Mode = val
+	default:
+		return fmt.Errorf("unknown dht param: %v", name)
+	}
+	return err
+}
+
+// CheckPublic returns an error if p cannot be used on the public dht.
+func (p DhtParams) CheckPublic() error {
+	if p.BucketSize > 0 && p.BucketSize != PublicBucketSize {
+		return fmt.Errorf("bucket-size must be %d on the public dht. other sizes only work in local networks, see dhtexp", PublicBucketSize)
+	}
+	return nil
+}
+
+func (p DhtParams) String() string {
+	var s []string
+	if p.Alpha > 0 {
+		s = append(s, fmt.Sprintf("alpha=%d", p.Alpha))
+	}
+	if p.BucketSize > 0 {
+		s = append(s, fmt.Sprintf("bucket-size=%d", p.BucketSize))
+	}
+	if p.Beta > 0 {
+		s = append(s, fmt.Sprintf("beta=%d", p.Beta))
+	}
+	if p.Mode != "" {
+		s = append(s, "mode="+p.Mode)
+	}
+	if len(s) < 1 {
+		return "default"
+	}
+	return strings.Join(s, ",")
+}
+
+func (p DhtParams) Options() []dht.Option {
+	var opts []dht.Option
+	if p.Alpha > 0 {
+		opts = append(opts, dht.Concurrency(p.Alpha))
+	}
+	if p.BucketSize > 0 {
+		opts = append(opts, dht.BucketSize(p.BucketSize))
+	}
+	if p.Beta > 0 {
+		opts = append(opts, dht.Resiliency(p.Beta))
+	}
+	if m, ok := dhtModes[p.Mode]; ok {
+		opts = append(opts, dht.Mode(m))
+	}
+	return opts
+}
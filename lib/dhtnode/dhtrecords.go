@@ -0,0 +1,137 @@
+package dhtnode
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+	ds "github.com/ipfs/go-datastore"
+	dsq "github.com/ipfs/go-datastore/query"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	providers "github.com/libp2p/go-libp2p-kad-dht/providers"
+	base32 "github.com/multiformats/go-base32"
+)
+
+const (
+	RecordValue    = "value"
+	RecordProvider = "provider"
+)
+
+// HasValue reports whether the node's datastore holds a value record for key.
+func (n *Node) HasValue(key string) (bool, error) {
+	// same key mapping as kad-dht's (unexported) mkDsKey
+	k := ds.NewKey(base32.RawStdEncoding.EncodeToString([]byte(key)))
+	return n.Datastore.Has(k)
+}
+
+// HasProvider reports whether the node's provider manager holds any
+// provider record for c.
+func (n *Node) HasProvider(ctx context.Context, c cid.Cid) bool {
+	return len(n.DHT.ProviderManager.GetProviders(ctx, c.Hash())) > 0
+}
+
+// RecordCounts counts the value and provider records in the node's
+// datastore.
+func (n *Node) RecordCounts() (values, provs int, err error) {
+	res, err := n.Datastore.Query(dsq.Query{KeysOnly: true})
+	if err != nil {
+		return 0, 0, err
+	}
+	defer res.Close()
+
+	for e := range res.Next() {
+		if e.Error != nil {
+			return 0, 0, e.Error
+		}
+		if strings.HasPrefix(e.Key, providers.ProvidersKeyPrefix) {
+			provs++
+		} else {
+			values++
+		}
+	}
+	return values, provs, nil
+}
+
+// PlacementHolder is a node holding a record.
+type PlacementHolder struct {
+	ID    peer.ID
+	Rank  int // 1-based rank by xor distance to the key, among all nodes
+	Ideal bool
+}
+
+// Placement compares the nodes that store a record with the ideal holders:
+// the K nodes closest to the key.
+type Placement struct {
+	Key     string
+	Kind    string // RecordValue or RecordProvider
+	K       int
+	Holders []PlacementHolder
+	Missing []PlacementHolder // ideal holders without the record
+}
+
+// Placement audits which nodes in the network store the record for key.
+// Keys that parse as a cid are audited as provider records, anything else
+// as a value record.
+func (net *Net) Placement(ctx context.Context, key string, k int) (*Placement, error) {
+	p := &Placement{Key: key, Kind: RecordValue, K: k}
+	target := key
+
+	c, err := cid.Decode(key)
+	if err == nil {
+		p.Kind = RecordProvider
+		target = string(c.Hash())
+	}
+
+	ranked := net.ClosestPeers(target, len(net.Nodes))
+	rank := make(map[peer.ID]int, len(ranked))
+	for i, id := range ranked {
+		rank[id] = i + 1
+	}
+
+	holds := map[peer.ID]bool{}
+	for _, n := range net.Nodes {
+		has := false
+		if p.Kind == RecordProvider {
+			has = n.HasProvider(ctx, c)
+		} else {
+			has, err = n.HasValue(key)
+			if err != nil {
+				return nil, err
+			}
+		}
+		holds[n.ID()] = has
+	}
+
+	for i, id := range ranked {
+		h := PlacementHolder{ID: id, Rank: rank[id], Ideal: i < k}
+		switch {
+		case holds[id]:
+			p.Holders = append(p.Holders, h)
+		case h.Ideal:
+			p.Missing = append(p.Missing, h)
+		}
+	}
+	return p, nil
+}
+
+func PrintPlacement(w io.Writer, p *Placement) {
+	ideal := 0
+	for _, h := range p.Holders {
+		if h.Ideal {
+			ideal++
+		}
+	}
+
+	fmt.Fprintf(w, "%s record %s: %d holders, %d/%d ideal holders\n",
+		p.Kind, p.Key, len(p.Holders), ideal, p.K)
+	fmt.Fprintln(w, "holders:")
+	for i, h := range p.Holders {
+		fmt.Fprintf(w, "  %d %v rank=%d ideal=%v\n", i, h.ID, h.Rank, h.Ideal)
+	}
+	fmt.Fprintln(w, "missing ideal holders:")
+	for i, h := range p.Missing {
+		fmt.Fprintf(w, "  %d %v rank=%d\n", i, h.ID, h.Rank)
+	}
+}
@@ -0,0 +1,89 @@
+package dhtnode
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	network "github.com/libp2p/go-libp2p-core/network"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+)
+
+// RTPeer is an entry in a node's kad-dht routing table.
+type RTPeer struct {
+	ID  peer.ID
+	CPL int // common prefix length with self
+
+	AddedAt      time.Time
+	LastUsefulAt time.Time
+	LastSeenAt   time.Time // last successful outbound query
+	Latency      time.Duration
+	Connected    bool
+}
+
+// RTBucket groups routing table entries by common prefix length with self.
+// kbucket puts a peer in bucket min(cpl, numBuckets-1), so the deepest
+// buckets here may share one unsplit kbucket.
+type RTBucket struct {
+	CPL   int
+	Peers []RTPeer
+}
+
+// RoutingTableBuckets lists the contents of the node's kad-dht routing table,
+// ordered by bucket. Unlike Peers(), these are routing table entries, not
+// connections.
+func (n *Node) RoutingTableBuckets() []RTBucket {
+	self := kb.ConvertPeerID(n.ID())
+	pstore := n.Host.Peerstore()
+	netw := n.Host.Network()
+
+	byCpl := map[int][]RTPeer{}
+	for _, pi := range n.DHT.RoutingTable().GetPeerInfos() {
+		cpl := kb.CommonPrefixLen(self, kb.ConvertPeerID(pi.Id))
+		byCpl[cpl] = append(byCpl[cpl], RTPeer{
+			ID:           pi.Id,
+			CPL:          cpl,
+			AddedAt:      pi.AddedAt,
+			LastUsefulAt: pi.LastUsefulAt,
+			LastSeenAt:   pi.LastSuccessfulOutboundQueryAt,
+			Latency:      pstore.LatencyEWMA(pi.Id),
+			Connected:    netw.Connectedness(pi.Id) == network.Connected,
+		})
+	}
+
+	buckets := make([]RTBucket, 0, len(byCpl))
+	for cpl, ps := range byCpl {
+		sort.Slice(ps, func(i, j int) bool { return ps[i].ID < ps[j].ID })
+		buckets = append(buckets, RTBucket{CPL: cpl, Peers: ps})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].CPL < buckets[j].CPL })
+	return buckets
+}
+
+func PrintRoutingTable(w io.Writer, n *Node) {
+	buckets := n.RoutingTableBuckets()
+	size := 0
+	for _, b := range buckets {
+		size += len(b.Peers)
+	}
+
+	now := time.Now()
+	fmt.Fprintf(w, "%v routing table: %d peers in %d buckets\n", n.ID(), size, len(buckets))
+	for _, b := range buckets {
+		fmt.Fprintf(w, "bucket cpl=%d: %d peers\n", b.CPL, len(b.Peers))
+		for i, p := range b.Peers {
+			fmt.Fprintf(w, "  %d %v latency=%v useful=%v seen=%v added=%v connected=%v\n",
+				i, p.ID, p.Latency, since(now, p.LastUsefulAt), since(now, p.LastSeenAt),
+				since(now, p.AddedAt), p.Connected)
+		}
+	}
+}
+
+func since(now, t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	return now.Sub(t).Round(time.Millisecond).String() + "-ago"
+}
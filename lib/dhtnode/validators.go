@@ -0,0 +1,293 @@
+package dhtnode
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	ipns "github.com/ipfs/go-ipns"
+	peerstore "github.com/libp2p/go-libp2p-core/peerstore"
+	record "github.com/libp2p/go-libp2p-record"
+)
+
+// Schemas a namespace checks its records against.
+const (
+	SchemaAny     = "any"
+	SchemaUTF8    = "utf8"
+	SchemaJSON    = "json"     // a json object
+	SchemaJSONSeq = "json-seq" // a json object with a non-negative integer "seq"
+)
+
+// Select policies: which of the records of a key is best.
+const (
+	SelectFirst      = "first"       // the first found, as the blank /v/ namespace does
+	SelectHighestSeq = "highest-seq" // the highest "seq" of json-seq records, the first on ties
+	SelectLexical    = "lexical"     // the bytewise greatest
+)
+
+var (
+	schemas  = []string{SchemaAny, SchemaUTF8, SchemaJSON, SchemaJSONSeq}
+	policies = []string{SelectFirst, SelectHighestSeq, SelectLexical}
+)
+
+// ValidatorCfg configures the record namespaces a node accepts. /pk/ is
+// always accepted. The zero ValidatorCfg is DefaultValidatorCfg.
+type ValidatorCfg struct {
+	IPNS       bool // validate /ipns/ records with ipns.Validator
+	Namespaces map[string]NamespaceCfg
+}
+
+// NamespaceCfg is how a namespace validates its records and selects the
+// best. Empty Schema and Select are any and first.
+type NamespaceCfg struct {
+	Schema  string
+	Select  string
+	MaxSize int // bytes. 0 for no limit
+
+	// Validator, if set, is used instead of the above.
+	Validator record.Validator
+}
+
+// DefaultValidatorCfg accepts valid /ipns/ records, and anything under /v/.
+func DefaultValidatorCfg() ValidatorCfg {
+	return ValidatorCfg{IPNS: true, Namespaces: map[string]NamespaceCfg{"v": {}}}
+}
+
+// ParseValidatorCfg parses namespaces in the form
+// "ipns;v;app:schema=json-seq,select=highest-seq,max-size=4096".
+func ParseValidatorCfg(s string) (ValidatorCfg, error) {
+	c := ValidatorCfg{Namespaces: map[string]NamespaceCfg{}}
+	for _, ns := range strings.Split(s, ";") {
+		if ns == "" {
+			continue
+		}
+		parts := strings.SplitN(ns, ":", 2)
+		name := parts[0]
+		if name == "ipns" {
+			if len(parts) == 2 {
+				return c, fmt.Errorf("ipns takes no validator options")
+			}
+			c.IPNS = true
+			continue
+		}
+
+		var n NamespaceCfg
+		if len(parts) == 2 {
+			for _, kv := range strings.Split(parts[1], ",") {
+				if kv == "" {
+					continue
+				}
+				opt := strings.SplitN(kv, "=", 2)
+				if len(opt) != 2 {
+					return c, fmt.Errorf("validator option format: <name>=<value>, got %v", kv)
+				}
+				if err := n.Set(opt[0], opt[1]); err != nil {
+					return c, fmt.Errorf("%s: %w", name, err)
+				}
+			}
+		}
+		c.Namespaces[name] = n
+	}
+	return c, c.check()
+}
+
+func (c ValidatorCfg) check() error {
+	for name, n := range c.Namespaces {
+		switch {
+		case name == "" || strings.Contains(name, "/"):
+			return fmt.Errorf("bad namespace: %q", name)
+		case name == "pk" || name == "ipns":
+			return fmt.Errorf("namespace %v is built in", name)
+		}
+		if err := n.check(); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// validator returns the validator of the namespaces, /pk/ included.
+func (c ValidatorCfg) validator(kb peerstore.KeyBook) (record.NamespacedValidator, error) {
+	if !c.IPNS && c.Namespaces == nil {
+		c = DefaultValidatorCfg()
+	}
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	vs := record.NamespacedValidator{"pk": record.PublicKeyValidator{}}
+	if c.IPNS {
+		vs["ipns"] = ipns.Validator{KeyBook: kb}
+	}
+	for name, n := range c.Namespaces {
+		switch {
+		case n.Validator != nil:
+			vs[name] = n.Validator
+		case n == NamespaceCfg{}:
+			vs[name] = blankValidator{}
+		default:
+			vs[name] = namespaceValidator{n}
+		}
+	}
+	return vs, nil
+}
+
+// publicValidator tells if kad-dht allows v on the public dht: it must
+// validate /pk/ and /ipns/ records, and nothing else.
+func publicValidator(v record.NamespacedValidator) bool {
+	_, pk := v["pk"].(record.PublicKeyValidator)
+	_, ipnsOK := v["ipns"].(ipns.Validator)
+	return len(v) == 2 && pk && ipnsOK
+}
+
+func (c ValidatorCfg) String() string {
+	var s []string
+	if c.IPNS {
+		s = append(s, "ipns")
+	}
+	var names []string
+	for name := range c.Namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if opts := c.Namespaces[name].String(); opts != "" {
+			name += ":" + opts
+		}
+		s = append(s, name)
+	}
+	if len(s) < 1 {
+		return "default"
+	}
+	return strings.Join(s, ";")
+}
+
+// Set sets an option by name: schema, select or max-size.
+func (n *NamespaceCfg) Set(name, val string) error {
+	var err error
+	switch name {
+	case "schema":
+		if !inStrings(val, schemas) {
+			return fmt.Errorf("unknown schema: %v", val)
+		}
+		n.Schema = val
+	case "select":
+		if !inStrings(val, policies) {
+			return fmt.Errorf("unknown select policy: %v", val)
+		}
+		n.Select = val
+	case "max-size":
+		n.MaxSize, err = strconv.Atoi(val)
+	default:
+		return fmt.Errorf("unknown validator option: %v", name)
+	}
+	return err
+}
+
+func (n NamespaceCfg) check() error {
+	if n.MaxSize < 0 {
+		return fmt.Errorf("max-size must not be negative")
+	}
+	if n.Select == SelectHighestSeq && n.Schema != SchemaJSONSeq && n.Validator == nil {
+		return fmt.Errorf("select=%s needs schema=%s", SelectHighestSeq, SchemaJSONSeq)
+	}
+	return nil
+}
+
+func (n NamespaceCfg) String() string {
+	var s []string
+	if n.Schema != "" {
+		s = append(s, "schema="+n.Schema)
+	}
+	if n.Select != "" {
+		s = append(s, "select="+n.Select)
+	}
+	if n.MaxSize > 0 {
+		s = append(s, fmt.Sprintf("max-size=%d", n.MaxSize))
+	}
+	if n.Validator != nil {
+		s = append(s, fmt.Sprintf("validator=%T", n.Validator))
+	}
+	return strings.Join(s, ",")
+}
+
+func inStrings(s string, ss []string) bool {
+	for _, x := range ss {
+		if s == x {
+			return true
+		}
+	}
+	return false
+}
+
+// namespaceValidator checks records against a NamespaceCfg.
+type namespaceValidator struct {
+	cfg NamespaceCfg
+}
+
+func (v namespaceValidator) Validate(_ string, val []byte) error {
+	if v.cfg.MaxSize > 0 && len(val) > v.cfg.MaxSize {
+		return fmt.Errorf("record is %d bytes, over %d", len(val), v.cfg.MaxSize)
+	}
+	switch v.cfg.Schema {
+	case SchemaUTF8:
+		if !utf8.Valid(val) {
+			return errors.New("record is not utf-8")
+		}
+	case SchemaJSON:
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(val, &obj); err != nil || obj == nil {
+			return fmt.Errorf("record is not a json object")
+		}
+	case SchemaJSONSeq:
+		_, err := recordSeq(val)
+		return err
+	}
+	return nil
+}
+
+func (v namespaceValidator) Select(_ string, vals [][]byte) (int, error) {
+	if len(vals) < 1 {
+		return 0, errors.New("no records to select from")
+	}
+	best := 0
+	switch v.cfg.Select {
+	case SelectHighestSeq:
+		best = -1
+		var bestSeq uint64
+		for i, val := range vals {
+			seq, err := recordSeq(val)
+			if err == nil && (best < 0 || seq > bestSeq) {
+				best, bestSeq = i, seq
+			}
+		}
+		if best < 0 {
+			return 0, errors.New("no record has a seq")
+		}
+	case SelectLexical:
+		for i, val := range vals {
+			if bytes.Compare(val, vals[best]) > 0 {
+				best = i
+			}
+		}
+	}
+	return best, nil
+}
+
+// recordSeq returns the "seq" of a json-seq record.
+func recordSeq(val []byte) (uint64, error) {
+	var r struct {
+		Seq *uint64 `json:"seq"`
+	}
+	if err := json.Unmarshal(val, &r); err != nil {
+		return 0, fmt.Errorf("record is not a json object with an integer seq")
+	}
+	if r.Seq == nil {
+		return 0, errors.New("record has no seq")
+	}
+	return *r.Seq, nil
+}
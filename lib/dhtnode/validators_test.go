@@ -0,0 +1,156 @@
+package dhtnode
+
+import (
+	"strings"
+	"testing"
+
+	ipns "github.com/ipfs/go-ipns"
+	record "github.com/libp2p/go-libp2p-record"
+)
+
+func TestParseValidatorCfg(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string // String of the result, or the start of the error
+		err  bool
+	}{
+		{in: "", want: "default"},
+		{in: "ipns", want: "ipns"},
+		{in: "ipns;v", want: "ipns;v"},
+		{in: ";;v;;", want: "v"},
+		{in: "app:schema=json-seq,select=highest-seq,max-size=4096;ipns",
+			want: "ipns;app:schema=json-seq,select=highest-seq,max-size=4096"},
+		{in: "app:schema=utf8,", want: "app:schema=utf8"},
+		{in: "app:select=lexical", want: "app:select=lexical"},
+
+		{in: "ipns:schema=json", want: "ipns takes no validator options", err: true},
+		{in: "pk", want: "namespace pk is built in", err: true},
+		{in: "app:schema=xml", want: "app: unknown schema", err: true},
+		{in: "app:select=last", want: "app: unknown select policy", err: true},
+		{in: "app:size=1", want: "app: unknown validator option", err: true},
+		{in: "app:schema", want: "validator option format", err: true},
+		{in: "app:max-size=big", want: "app: ", err: true},
+		{in: "app:max-size=-1", want: "app: max-size must not be negative", err: true},
+		{in: "app:select=highest-seq", want: "app: select=highest-seq needs schema=json-seq", err: true},
+		{in: "a/b", want: "bad namespace", err: true},
+	}
+	for _, c := range cases {
+		cfg, err := ParseValidatorCfg(c.in)
+		switch {
+		case c.err && err == nil:
+			t.Errorf("%q: got %v, want error %q", c.in, cfg, c.want)
+		case c.err && !strings.HasPrefix(err.Error(), c.want):
+			t.Errorf("%q: got error %q, want %q", c.in, err, c.want)
+		case !c.err && err != nil:
+			t.Errorf("%q: %v", c.in, err)
+		case !c.err && cfg.String() != c.want:
+			t.Errorf("%q: got %q, want %q", c.in, cfg, c.want)
+		}
+	}
+}
+
+func TestNamespaceValidate(t *testing.T) {
+	cases := []struct {
+		cfg NamespaceCfg
+		val string
+		ok  bool
+	}{
+		{NamespaceCfg{}, "\xff", true},
+		{NamespaceCfg{MaxSize: 3}, "abc", true},
+		{NamespaceCfg{MaxSize: 3}, "abcd", false},
+		{NamespaceCfg{Schema: SchemaUTF8}, "héllo", true},
+		{NamespaceCfg{Schema: SchemaUTF8}, "\xff", false},
+		{NamespaceCfg{Schema: SchemaJSON}, `{"a": 1}`, true},
+		{NamespaceCfg{Schema: SchemaJSON}, `[1]`, false},
+		{NamespaceCfg{Schema: SchemaJSON}, `null`, false},
+		{NamespaceCfg{Schema: SchemaJSONSeq}, `{"seq": 0}`, true},
+		{NamespaceCfg{Schema: SchemaJSONSeq}, `{"seq": 7, "v": "x"}`, true},
+		{NamespaceCfg{Schema: SchemaJSONSeq}, `{"v": "x"}`, false},
+		{NamespaceCfg{Schema: SchemaJSONSeq}, `{"seq": -1}`, false},
+		{NamespaceCfg{Schema: SchemaJSONSeq}, `{"seq": 1.5}`, false},
+		{NamespaceCfg{Schema: SchemaJSONSeq}, `{"seq": "1"}`, false},
+		{NamespaceCfg{Schema: SchemaJSONSeq, MaxSize: 8}, `{"seq": 10}`, false},
+	}
+	for _, c := range cases {
+		err := namespaceValidator{c.cfg}.Validate("/app/k", []byte(c.val))
+		if (err == nil) != c.ok {
+			t.Errorf("%v: Validate(%q) = %v, want ok %v", c.cfg, c.val, err, c.ok)
+		}
+	}
+}
+
+func TestNamespaceSelect(t *testing.T) {
+	seq := NamespaceCfg{Schema: SchemaJSONSeq, Select: SelectHighestSeq}
+	cases := []struct {
+		name string
+		cfg  NamespaceCfg
+		vals []string
+		want int // -1 for an error
+	}{
+		{"first", NamespaceCfg{}, []string{"b", "c", "a"}, 0},
+		{"empty", NamespaceCfg{}, nil, -1},
+		{"lexical", NamespaceCfg{Select: SelectLexical}, []string{"b", "c", "a"}, 1},
+		{"lexical tie", NamespaceCfg{Select: SelectLexical}, []string{"a", "c", "c"}, 1},
+		{"lexical prefix", NamespaceCfg{Select: SelectLexical}, []string{"ab", "a"}, 0},
+		{"highest seq", seq, []string{`{"seq": 1}`, `{"seq": 3}`, `{"seq": 2}`}, 1},
+		{"highest seq tie", seq, []string{`{"seq": 2, "v": "a"}`, `{"seq": 2, "v": "b"}`}, 0},
+		{"highest seq zero", seq, []string{`{"seq": 0}`}, 0},
+		{"no seq skipped", seq, []string{`{"v": "a"}`, `{"seq": 0}`, `junk`}, 1},
+		{"no seq at all", seq, []string{`{"v": "a"}`, `junk`}, -1},
+	}
+	for _, c := range cases {
+		vals := make([][]byte, len(c.vals))
+		for i, v := range c.vals {
+			vals[i] = []byte(v)
+		}
+		got, err := namespaceValidator{c.cfg}.Select("/app/k", vals)
+		switch {
+		case c.want < 0 && err == nil:
+			t.Errorf("%s: got %d, want error", c.name, got)
+		case c.want >= 0 && err != nil:
+			t.Errorf("%s: %v", c.name, err)
+		case c.want >= 0 && got != c.want:
+			t.Errorf("%s: got %d, want %d", c.name, got, c.want)
+		}
+	}
+}
+
+func TestPublicValidator(t *testing.T) {
+	pk := record.PublicKeyValidator{}
+	iv := ipns.Validator{}
+	cases := []struct {
+		name string
+		v    record.NamespacedValidator
+		want bool
+	}{
+		{"pk and ipns", record.NamespacedValidator{"pk": pk, "ipns": iv}, true},
+		{"pk only", record.NamespacedValidator{"pk": pk}, false},
+		{"extra namespace", record.NamespacedValidator{"pk": pk, "ipns": iv, "v": blankValidator{}}, false},
+		{"other ipns validator", record.NamespacedValidator{"pk": pk, "ipns": blankValidator{}}, false},
+		{"other pk validator", record.NamespacedValidator{"pk": blankValidator{}, "ipns": iv}, false},
+		{"ipns under another name", record.NamespacedValidator{"pk": pk, "v": iv}, false},
+	}
+	for _, c := range cases {
+		if got := publicValidator(c.v); got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	// what NewNode checks it with
+	for _, c := range []struct {
+		cfg  ValidatorCfg
+		want bool
+	}{
+		{ValidatorCfg{IPNS: true, Namespaces: map[string]NamespaceCfg{}}, true},
+		{ValidatorCfg{}, false}, // the default, with /v/
+		{ValidatorCfg{Namespaces: map[string]NamespaceCfg{}}, false},
+	} {
+		v, err := c.cfg.validator(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := publicValidator(v); got != c.want {
+			t.Errorf("%v: got %v, want %v", c.cfg, got, c.want)
+		}
+	}
+}
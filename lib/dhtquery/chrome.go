@@ -0,0 +1,126 @@
+package dhtquery
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// chromeEvent is an event in the Chrome trace event format, which
+// chrome://tracing and Perfetto (ui.perfetto.dev) open. Timestamps are in
+// microseconds.
+type chromeEvent struct {
+	Name string                 `json:"name"`
+	Cat  string                 `json:"cat,omitempty"`
+	Ph   string                 `json:"ph"`
+	Ts   int64                  `json:"ts"`
+	Dur  int64                  `json:"dur,omitempty"`
+	Pid  int                    `json:"pid"`
+	Tid  int                    `json:"tid"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+// WriteChromeTrace writes traces as Chrome trace event JSON. Every trace is
+// a process, with a track for the query as a whole, and a track per
+// contacted peer holding its Dial, Request and Put spans. Timestamps are
+// relative to the earliest trace start.
+func WriteChromeTrace(w io.Writer, ts ...*Trace) error {
+	var t0 time.Time
+	for _, t := range ts {
+		if t0.IsZero() || t.Start.Before(t0) {
+			t0 = t.Start
+		}
+	}
+	us := func(t time.Time) int64 {
+		return t.Sub(t0).Microseconds()
+	}
+
+	events := []chromeEvent{}
+	for i, t := range ts {
+		t.mu.Lock()
+		pid := i + 1
+		meta := func(tid int, name string, args map[string]interface{}) {
+			events = append(events, chromeEvent{Name: name, Ph: "M", Pid: pid, Tid: tid, Args: args})
+		}
+
+		meta(0, "process_name", map[string]interface{}{"name": fmt.Sprintf("%s %s (%s)", t.Cmd, t.Key, t.ID)})
+		meta(0, "process_sort_index", map[string]interface{}{"sort_index": pid})
+		meta(0, "thread_name", map[string]interface{}{"name": "query"})
+		args := map[string]interface{}{
+			"key":      t.Key,
+			"self":     t.Self.String(),
+			"messages": t.Messages,
+			"peers":    len(t.Peers),
+		}
+		if t.Err != "" {
+			args["err"] = t.Err
+		}
+		events = append(events, chromeEvent{
+			Name: t.Cmd,
+			Cat:  "query",
+			Ph:   "X",
+			Ts:   us(t.Start),
+			Dur:  t.End.Sub(t.Start).Microseconds(),
+			Pid:  pid,
+			Args: args,
+		})
+
+		for _, p := range t.Peers {
+			tid := p.Order + 1
+			name := fmt.Sprintf("%s d=%d hop=%d", p.Peer, p.XORDistance, p.Hops)
+			meta(tid, "thread_name", map[string]interface{}{"name": name})
+			meta(tid, "thread_sort_index", map[string]interface{}{"sort_index": tid})
+
+			for _, s := range p.Spans {
+				args := map[string]interface{}{
+					"peer":         p.Peer.String(),
+					"xor-distance": p.XORDistance,
+					"hops":         p.Hops,
+				}
+				if s.Err != "" {
+					args["err"] = s.Err
+				}
+				if s.Type == SpanRequest && p.Responded {
+					args["closer-peers"] = len(p.CloserPeers)
+					args["closer-peers-new"] = p.CloserPeersNew
+				}
+				events = append(events, chromeEvent{
+					Name: s.Type,
+					Cat:  "peer",
+					Ph:   "X",
+					Ts:   us(s.Start),
+					Dur:  s.Duration().Microseconds(),
+					Pid:  pid,
+					Tid:  tid,
+					Args: args,
+				})
+			}
+		}
+		t.mu.Unlock()
+	}
+
+	return json.NewEncoder(w).Encode(map[string]interface{}{
+		"traceEvents":     events,
+		"displayTimeUnit": "ms",
+	})
+}
+
+// ReadTraces reads traces stored as JSON, one per line, as the experiment
+// runner writes them.
+func ReadTraces(r io.Reader) ([]*Trace, error) {
+	var ts []*Trace
+	dec := json.NewDecoder(bufio.NewReader(r))
+	for {
+		t := &Trace{}
+		err := dec.Decode(t)
+		if err == io.EOF {
+			return ts, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		ts = append(ts, t)
+	}
+}
@@ -0,0 +1,351 @@
+// Package dhtquery records the routing.QueryEvent stream that kad-dht
+// publishes on a query's context into a per-peer trace of the lookup.
+package dhtquery
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+)
+
+const (
+	SpanDial    = "Dial"
+	SpanRequest = "Request"
+	SpanPut     = "Put"
+)
+
+// Span is a timed interaction with a single peer.
+type Span struct {
+	Type  string
+	Start time.Time
+	End   time.Time
+	Err   string `json:",omitempty"`
+}
+
+func (s Span) Duration() time.Duration {
+	return s.End.Sub(s.Start)
+}
+
+// PeerTrace is everything a query did with one peer.
+type PeerTrace struct {
+	Peer        peer.ID
+	Order       int // order of first contact in the query
+	XORDistance int // bit length of the xor distance to the target (1-256)
+	Hops        int // 1 for seed peers, n+1 for peers learnt from a hop n peer
+	Spans       []Span
+	Responded   bool
+	Err         string `json:",omitempty"`
+
+	CloserPeers    []peer.ID // peers this peer returned
+	CloserPeersNew int       // how many of those the query had not heard of
+}
+
+// Trace is the record of a single dht query.
+type Trace struct {
+	ID     string
+	Cmd    string
+	Key    string
+	Target string // kad keyspace key being looked up
+	Self   peer.ID
+	Start  time.Time
+	End    time.Time
+	Err    string `json:",omitempty"`
+
+	Messages int // requests sent to peers
+	Peers    []*PeerTrace
+
+	mu     sync.Mutex
+	byPeer map[peer.ID]*PeerTrace
+	heard  map[peer.ID]int // hops of peers heard of, but not yet contacted
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Observer is called with every query event a trace records.
+type Observer func(t *Trace, now time.Time, e *routing.QueryEvent)
+
+// Record starts tracing a query. The returned context must be used to run
+// the query, and Finish called once it returns. target is the key as kad-dht
+// sees it (e.g. string(cid.Hash()) for provider queries). observers are
+// called with the events, after the trace handled them.
+func Record(ctx context.Context, self peer.ID, cmd, key, target string, observers ...Observer) (context.Context, *Trace) {
+	t := &Trace{
+		ID:     newID(),
+		Cmd:    cmd,
+		Key:    key,
+		Target: target,
+		Self:   self,
+		Start:  time.Now(),
+		byPeer: map[peer.ID]*PeerTrace{},
+		heard:  map[peer.ID]int{},
+		done:   make(chan struct{}),
+	}
+
+	ctx, t.cancel = context.WithCancel(ctx)
+	ctx, events := routing.RegisterForQueryEvents(ctx)
+	go func() {
+		defer close(t.done)
+		for e := range events {
+			now := time.Now()
+			t.handleEvent(now, e)
+			for _, o := range observers {
+				o(t, now, e)
+			}
+		}
+	}()
+	return ctx, t
+}
+
+// Finish stops recording, and waits for outstanding events to be processed.
+// Spans that never completed are closed with an error.
+func (t *Trace) Finish(err error) {
+	t.cancel()
+	<-t.done
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.End = time.Now()
+	if err != nil {
+		t.Err = err.Error()
+	}
+	for _, p := range t.Peers {
+		for i := range p.Spans {
+			if p.Spans[i].End.IsZero() {
+				p.Spans[i].End = t.End
+				if p.Spans[i].Type != SpanPut {
+					p.Spans[i].Err = "no response"
+				}
+			}
+		}
+	}
+}
+
+// Snapshot copies the trace, so that a query that is still running can be
+// read from.
+func (t *Trace) Snapshot() *Trace {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	c := &Trace{
+		ID:       t.ID,
+		Cmd:      t.Cmd,
+		Key:      t.Key,
+		Target:   t.Target,
+		Self:     t.Self,
+		Start:    t.Start,
+		End:      t.End,
+		Err:      t.Err,
+		Messages: t.Messages,
+	}
+	for _, p := range t.Peers {
+		pc := *p
+		pc.Spans = append([]Span{}, p.Spans...)
+		pc.CloserPeers = append([]peer.ID{}, p.CloserPeers...)
+		c.Peers = append(c.Peers, &pc)
+	}
+	return c
+}
+
+func (t *Trace) Duration() time.Duration {
+	return t.End.Sub(t.Start)
+}
+
+// Responded lists the peers that answered a request, in order of contact.
+func (t *Trace) Responded() []peer.ID {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var ps []peer.ID
+	for _, p := range t.Peers {
+		if p.Responded {
+			ps = append(ps, p.Peer)
+		}
+	}
+	return ps
+}
+
+// MaxHops is the depth of the lookup: the largest hop count of a peer that
+// responded.
+func (t *Trace) MaxHops() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	max := 0
+	for _, p := range t.Peers {
+		if p.Responded && p.Hops > max {
+			max = p.Hops
+		}
+	}
+	return max
+}
+
+func (t *Trace) handleEvent(now time.Time, e *routing.QueryEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if e.ID == "" {
+		if e.Type == routing.QueryError {
+			t.Err = e.Extra
+		}
+		return
+	}
+
+	switch e.Type {
+	case routing.DialingPeer:
+		p := t.peer(e.ID)
+		p.Spans = append(p.Spans, Span{Type: SpanDial, Start: now})
+	case routing.SendingQuery:
+		p := t.peer(e.ID)
+		p.closeSpan(SpanDial, now, "")
+		p.Spans = append(p.Spans, Span{Type: SpanRequest, Start: now})
+		t.Messages++
+	case routing.PeerResponse:
+		p := t.peer(e.ID)
+		p.closeSpan(SpanRequest, now, "")
+		p.Responded = true
+		for _, ai := range e.Responses {
+			p.CloserPeers = append(p.CloserPeers, ai.ID)
+			if ai.ID == t.Self {
+				continue
+			}
+			if _, seen := t.byPeer[ai.ID]; seen {
+				continue
+			}
+			if _, seen := t.heard[ai.ID]; seen {
+				continue
+			}
+			t.heard[ai.ID] = p.Hops + 1
+			p.CloserPeersNew++
+		}
+	case routing.QueryError:
+		p := t.peer(e.ID)
+		if !p.closeSpan(SpanDial, now, e.Extra) {
+			p.closeSpan(SpanRequest, now, e.Extra)
+		}
+		p.Err = e.Extra
+	case routing.Value:
+		p := t.peer(e.ID)
+		p.Spans = append(p.Spans, Span{Type: SpanPut, Start: now})
+		t.Messages++
+	}
+}
+
+// peer returns the trace for p, creating it on first contact.
+func (t *Trace) peer(p peer.ID) *PeerTrace {
+	if pt, ok := t.byPeer[p]; ok {
+		return pt
+	}
+
+	hops, ok := t.heard[p]
+	if !ok {
+		hops = 1 // not learnt from anyone in this query: a seed peer
+	}
+	delete(t.heard, p)
+
+	pt := &PeerTrace{
+		Peer:        p,
+		Order:       len(t.Peers),
+		XORDistance: XORDistance(p, t.Target),
+		Hops:        hops,
+	}
+	t.byPeer[p] = pt
+	t.Peers = append(t.Peers, pt)
+	return pt
+}
+
+// closeSpan ends the latest open span of type typ, if any.
+func (p *PeerTrace) closeSpan(typ string, now time.Time, err string) bool {
+	for i := len(p.Spans) - 1; i >= 0; i-- {
+		s := &p.Spans[i]
+		if s.Type == typ && s.End.IsZero() {
+			s.End = now
+			s.Err = err
+			return true
+		}
+	}
+	return false
+}
+
+// XORDistance is the bit length of the xor distance between p and the kad
+// key target, i.e. 256 minus their common prefix length.
+func XORDistance(p peer.ID, target string) int {
+	return 256 - kb.CommonPrefixLen(kb.ConvertPeerID(p), kb.ConvertKey(target))
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// PrintTrace prints a summary of t, and a row per contacted peer in order
+// of contact.
+func PrintTrace(w io.Writer, t *Trace) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	fmt.Fprintf(w, "query %s: %s %s\n", t.ID, t.Cmd, t.Key)
+	fmt.Fprintf(w, "took %v, %d messages, %d peers contacted\n",
+		t.Duration().Round(time.Millisecond), t.Messages, len(t.Peers))
+	if t.Err != "" {
+		fmt.Fprintf(w, "error: %s\n", t.Err)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "order\tpeer\thops\tdistance\tresponded\tcloser\tnew\ttime")
+	for _, p := range t.Peers {
+		var d time.Duration
+		for _, s := range p.Spans {
+			d += s.Duration()
+		}
+		fmt.Fprintf(tw, "%d\t%v\t%d\t%d\t%v\t%d\t%d\t%v\n", p.Order, p.Peer, p.Hops,
+			p.XORDistance, p.Responded, len(p.CloserPeers), p.CloserPeersNew,
+			d.Round(time.Millisecond))
+	}
+	tw.Flush()
+}
+
+// LikelyResponder guesses which peer sent a response that arrived at time
+// at: the peer that responded to a request started before at, closest to
+// at. kad-dht does not tell which peer a record came from, so this is best
+// effort. If no request was sent before at, the response came from the
+// node itself. If none was answered yet, it returns "".
+func (t *Trace) LikelyResponder(at time.Time) peer.ID {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var best peer.ID
+	bestGap, sent := time.Duration(-1), false
+	for _, p := range t.Peers {
+		for _, s := range p.Spans {
+			if s.Type != SpanRequest || s.Start.After(at) {
+				continue
+			}
+			sent = true
+			if s.End.IsZero() || s.Err != "" {
+				continue
+			}
+			gap := s.End.Sub(at)
+			if gap < 0 {
+				gap = -gap
+			}
+			if bestGap < 0 || gap < bestGap {
+				best, bestGap = p.Peer, gap
+			}
+		}
+	}
+	if !sent {
+		return t.Self
+	}
+	return best
+}
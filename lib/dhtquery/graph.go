@@ -0,0 +1,210 @@
+package dhtquery
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"sort"
+
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// graph is the shape of a lookup: the contacted peers, and which peer
+// returned which as closer.
+type graph struct {
+	t       *Trace
+	byPeer  map[peer.ID]*PeerTrace
+	minDist int
+	maxDist int
+}
+
+func newGraph(t *Trace) *graph {
+	g := &graph{t: t, byPeer: map[peer.ID]*PeerTrace{}, minDist: 256}
+	for _, p := range t.Peers {
+		g.byPeer[p.Peer] = p
+		if p.XORDistance < g.minDist {
+			g.minDist = p.XORDistance
+		}
+		if p.XORDistance > g.maxDist {
+			g.maxDist = p.XORDistance
+		}
+	}
+	return g
+}
+
+// edges calls f for every contacted peer b that a returned. discovery is
+// true if b's hop count came from a.
+func (g *graph) edges(f func(a, b *PeerTrace, discovery bool)) {
+	for _, a := range g.t.Peers {
+		for _, id := range a.CloserPeers {
+			b, ok := g.byPeer[id]
+			if !ok || b == a {
+				continue
+			}
+			f(a, b, b.Hops == a.Hops+1)
+		}
+	}
+}
+
+// color shades peers from red, the furthest from the target, to green,
+// the closest.
+func (g *graph) color(p *PeerTrace) string {
+	f := 0.0
+	if g.maxDist > g.minDist {
+		f = float64(g.maxDist-p.XORDistance) / float64(g.maxDist-g.minDist)
+	}
+	r := int(220 * (1 - f))
+	gr := int(200 * f)
+	return fmt.Sprintf("#%02x%02x60", r+35, gr+55)
+}
+
+func shortID(p peer.ID) string {
+	s := p.String()
+	if len(s) > 8 {
+		return s[len(s)-8:]
+	}
+	return s
+}
+
+func (g *graph) label(p *PeerTrace) string {
+	return fmt.Sprintf("%s\nd=%d hop=%d", shortID(p.Peer), p.XORDistance, p.Hops)
+}
+
+// WriteDOT writes the lookup graph of t in graphviz DOT. Peers are ranked
+// by hops, and colored by xor distance to the target. Solid edges are the
+// ones peers were discovered through, dashed ones were returned again
+// later. Peers that never responded have a red outline.
+func WriteDOT(w io.Writer, t *Trace) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	g := newGraph(t)
+
+	fmt.Fprintf(w, "digraph %q {\n", t.Cmd+" "+t.Key)
+	fmt.Fprintln(w, `  rankdir=LR;`)
+	fmt.Fprintf(w, "  label=%q;\n", fmt.Sprintf("%s %s (%s) %v", t.Cmd, t.Key, t.ID, t.Duration()))
+	fmt.Fprintln(w, `  node [shape=box, style="filled,rounded", fontname=monospace, fontsize=10];`)
+	fmt.Fprintf(w, "  self [label=%q, shape=doublecircle, fillcolor=\"#dddddd\"];\n", "self\n"+shortID(t.Self))
+
+	byHops := map[int][]*PeerTrace{}
+	for _, p := range t.Peers {
+		byHops[p.Hops] = append(byHops[p.Hops], p)
+	}
+	var hops []int
+	for h := range byHops {
+		hops = append(hops, h)
+	}
+	sort.Ints(hops)
+	for _, h := range hops {
+		fmt.Fprintf(w, "  { rank=same;")
+		for _, p := range byHops[h] {
+			fmt.Fprintf(w, " %q;", p.Peer.String())
+		}
+		fmt.Fprintln(w, " }")
+	}
+
+	for _, p := range t.Peers {
+		outline := "black"
+		if !p.Responded {
+			outline = "red"
+		}
+		fmt.Fprintf(w, "  %q [label=%q, fillcolor=%q, color=%q];\n",
+			p.Peer.String(), g.label(p), g.color(p), outline)
+		if p.Hops == 1 {
+			fmt.Fprintf(w, "  self -> %q;\n", p.Peer.String())
+		}
+	}
+	g.edges(func(a, b *PeerTrace, discovery bool) {
+		style := "solid"
+		if !discovery {
+			style = "dashed"
+		}
+		fmt.Fprintf(w, "  %q -> %q [style=%s];\n", a.Peer.String(), b.Peer.String(), style)
+	})
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// WriteSVG lays out and draws the lookup graph of t, without needing
+// graphviz: a column per hop, with peers sorted by xor distance, closest
+// at the top. Styles are the same as WriteDOT's.
+func WriteSVG(w io.Writer, t *Trace) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	g := newGraph(t)
+
+	const (
+		colWidth  = 200
+		rowHeight = 44
+		margin    = 40
+		boxW      = 120
+		boxH      = 32
+	)
+
+	cols := map[int][]*PeerTrace{}
+	maxHops, maxRows := 0, 1
+	for _, p := range t.Peers {
+		cols[p.Hops] = append(cols[p.Hops], p)
+		if p.Hops > maxHops {
+			maxHops = p.Hops
+		}
+	}
+	type pos struct{ x, y int }
+	at := map[peer.ID]pos{}
+	for h, ps := range cols {
+		sort.Slice(ps, func(i, j int) bool { return ps[i].XORDistance < ps[j].XORDistance })
+		for i, p := range ps {
+			at[p.Peer] = pos{margin + h*colWidth, margin + 30 + i*rowHeight}
+		}
+		if len(ps) > maxRows {
+			maxRows = len(ps)
+		}
+	}
+	self := pos{margin, margin + 30}
+
+	width := 2*margin + maxHops*colWidth + boxW
+	height := 2*margin + 30 + maxRows*rowHeight
+	fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-family="monospace" font-size="10">`+"\n", width, height)
+	fmt.Fprintln(w, `<defs><marker id="arrow" viewBox="0 0 10 10" refX="10" refY="5" markerWidth="6" markerHeight="6" orient="auto"><path d="M0,0 L10,5 L0,10 z" fill="#555"/></marker></defs>`)
+	fmt.Fprintf(w, `<text x="%d" y="%d" font-size="12">%s</text>`+"\n", margin, margin-10,
+		html.EscapeString(fmt.Sprintf("%s %s (%s) %v", t.Cmd, t.Key, t.ID, t.Duration())))
+
+	line := func(a, b pos, dashed bool) {
+		dash := ""
+		if dashed {
+			dash = ` stroke-dasharray="4,3" stroke-opacity="0.4"`
+		}
+		fmt.Fprintf(w, `<line x1="%d" y1="%d" x2="%d" y2="%d" stroke="#555" marker-end="url(#arrow)"%s/>`+"\n",
+			a.x+boxW, a.y+boxH/2, b.x, b.y+boxH/2, dash)
+	}
+	for _, p := range cols[1] {
+		line(self, at[p.Peer], false)
+	}
+	g.edges(func(a, b *PeerTrace, discovery bool) {
+		line(at[a.Peer], at[b.Peer], !discovery)
+	})
+
+	box := func(p pos, fill, outline, title string, lines ...string) {
+		fmt.Fprintf(w, `<g><title>%s</title><rect x="%d" y="%d" width="%d" height="%d" rx="6" fill="%s" stroke="%s"/>`,
+			html.EscapeString(title), p.x, p.y, boxW, boxH, fill, outline)
+		for i, l := range lines {
+			fmt.Fprintf(w, `<text x="%d" y="%d">%s</text>`, p.x+6, p.y+13+i*12, html.EscapeString(l))
+		}
+		fmt.Fprintln(w, `</g>`)
+	}
+	box(self, "#dddddd", "black", t.Self.String(), "self", shortID(t.Self))
+	for _, p := range t.Peers {
+		outline := "black"
+		title := p.Peer.String()
+		if !p.Responded {
+			outline = "red"
+			title += " (no response)"
+			if p.Err != "" {
+				title += " " + p.Err
+			}
+		}
+		box(at[p.Peer], g.color(p), outline, title,
+			shortID(p.Peer), fmt.Sprintf("d=%d hop=%d", p.XORDistance, p.Hops))
+	}
+	_, err := fmt.Fprintln(w, `</svg>`)
+	return err
+}
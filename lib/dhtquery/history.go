@@ -0,0 +1,56 @@
+package dhtquery
+
+import "sync"
+
+// History keeps the most recent traces.
+type History struct {
+	size   int
+	mu     sync.Mutex
+	traces []*Trace // oldest first
+}
+
+func NewHistory(size int) *History {
+	return &History{size: size}
+}
+
+func (h *History) Add(t *Trace) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.traces = append(h.traces, t)
+	if len(h.traces) > h.size {
+		h.traces = h.traces[len(h.traces)-h.size:]
+	}
+}
+
+// Get returns the trace with the given id, or nil.
+func (h *History) Get(id string) *Trace {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, t := range h.traces {
+		if t.ID == id {
+			return t
+		}
+	}
+	return nil
+}
+
+// Last returns the latest trace, or nil.
+func (h *History) Last() *Trace {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.traces) == 0 {
+		return nil
+	}
+	return h.traces[len(h.traces)-1]
+}
+
+// All returns the traces, oldest first.
+func (h *History) All() []*Trace {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]*Trace{}, h.traces...)
+}
@@ -3,22 +3,37 @@ package dhttracer
 import (
 	"bytes"
 	"context"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	cid "github.com/ipfs/go-cid"
 	dhtnode "github.com/libp2p/dht-tracer1/lib/dhtnode"
+	dhtquery "github.com/libp2p/dht-tracer1/lib/dhtquery"
+	workload "github.com/libp2p/dht-tracer1/lib/workload"
 	peer "github.com/libp2p/go-libp2p-core/peer"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+	kb "github.com/libp2p/go-libp2p-kbucket"
 )
 
 var Version = "1.0.0"
 
+// HistorySize is how many recent query traces a Tracer keeps.
+var HistorySize = 100
+
+// ErrStopping is returned for queries run once the tracer is stopping.
+var ErrStopping = errors.New("tracer is stopping")
+
 type cancelCtx struct {
 	context.Context
 
+	// cancel is guarded by Tracer.activeMu, not the tracer lock, so that
+	// Stop can cancel queries while a Reset waits for the lock.
 	cancel context.CancelFunc
 }
 
@@ -36,6 +51,11 @@ var (
 	CmdGetProviders = "get-providers"
 	CmdFindPeer     = "find-peer"
 	CmdPing         = "ping"
+
+	CmdGetClosestPeers = "get-closest-peers"
+	CmdSearchValue     = "search-value"
+	CmdIPNSPublish     = "ipns-publish"
+	CmdIPNSResolve     = "ipns-resolve"
 )
 
 var CtrlCmds = []string{
@@ -50,10 +70,24 @@ var QueryCmds = []string{
 	CmdGetProviders,
 	CmdFindPeer,
 	CmdPing,
+	CmdGetClosestPeers,
+	CmdSearchValue,
+	CmdIPNSPublish,
+	CmdIPNSResolve,
 }
 
 var AllCmds = append(QueryCmds, CtrlCmds...)
 
+// MutatingCmds change the tracer, or write to the dht. Read-only servers
+// refuse them.
+var MutatingCmds = []string{
+	CmdExit,
+	CmdReset,
+	CmdPutValue,
+	CmdAddProvider,
+	CmdIPNSPublish,
+}
+
 // func init() {
 //   AllCmds =
 // }
@@ -67,8 +101,25 @@ func cmdInGroup(c string, g []string) bool {
 	return false
 }
 
+// TraceSink receives the trace of every query a Tracer runs, once it
+// finished. Add must not block. Sinks that are io.Closers are closed when
+// the tracer stops, to flush them.
+type TraceSink interface {
+	Add(t *dhtquery.Trace)
+}
+
 type Tracer struct {
 	NodeCfg dhtnode.NodeCfg
+	History *dhtquery.History // traces of recent queries
+	Sinks   []TraceSink       // set before Start
+	Events  *EventBus
+	Keys    *Keystore // keys ipns-publish signs with. set before Start
+
+	activeMu sync.Mutex
+	active   map[string]*dhtquery.Trace // running queries, by id
+	stopping bool
+	running  sync.WaitGroup     // queries in Run, and workloads
+	ipnsSeqs map[peer.ID]uint64 // last ipns sequence numbers published, by name
 
 	Node *dhtnode.Node
 	ctx  cancelCtx
@@ -79,17 +130,42 @@ type Tracer struct {
 func NewTracer(cfg dhtnode.NodeCfg) *Tracer {
 	return &Tracer{
 		NodeCfg: cfg,
+		History: dhtquery.NewHistory(HistorySize),
+		Events:  NewEventBus(),
+		Keys:    NewKeystore(""),
+		active:  map[string]*dhtquery.Trace{},
+
+		ipnsSeqs: map[peer.ID]uint64{},
 	}
 }
 
+// AddSink adds a sink for the traces of queries run from now on.
+func (t *Tracer) AddSink(s TraceSink) {
+	t.Lock()
+	defer t.Unlock()
+	t.Sinks = append(t.Sinks, s)
+}
+
+// Nodes returns the tracer's node, for collectors that take a set of nodes.
+func (t *Tracer) Nodes() []*dhtnode.Node {
+	t.RLock()
+	defer t.RUnlock()
+	return []*dhtnode.Node{t.Node}
+}
+
 func (t *Tracer) Start() error {
 	t.Lock()
 	defer t.Unlock()
+	return t.start()
+}
 
+func (t *Tracer) start() error {
 	// setup the dht node's context
 	ctx, cancel := context.WithCancel(context.Background())
 	t.ctx.Context = ctx
+	t.activeMu.Lock()
 	t.ctx.cancel = cancel
+	t.activeMu.Unlock()
 
 	// create node
 	var err error
@@ -97,6 +173,7 @@ func (t *Tracer) Start() error {
 	if err != nil {
 		return err
 	}
+	t.Node.Host.Network().Notify(netNotifiee(t.Events))
 
 	err = dhtnode.Bootstrap(t.Node, t.NodeCfg.Bootstrap)
 	if err != nil {
@@ -105,112 +182,551 @@ func (t *Tracer) Start() error {
 	return nil
 }
 
-func (t *Tracer) Stop() {
-	panic("not yet implemented")
-	// t.Lock()
-	// defer t.Unlock()
+// Stop stops the tracer for good. It refuses new queries, and waits for
+// the running ones until ctx is done, then cancels them. Then it closes the
+// sinks that are io.Closers, and the node. It returns a report of what it
+// stopped.
+func (t *Tracer) Stop(ctx context.Context) (io.Reader, error) {
+	buf := bytes.NewBuffer(nil)
+
+	t.activeMu.Lock()
+	if t.stopping {
+		t.activeMu.Unlock()
+		return strings.NewReader("already stopped\n"), nil
+	}
+	t.stopping = true
+	running := len(t.active)
+	t.activeMu.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		t.running.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		fmt.Fprintf(buf, "drained %d running queries\n", running)
+	case <-ctx.Done():
+		t.activeMu.Lock()
+		left := len(t.active)
+		cancel := t.ctx.cancel
+		t.activeMu.Unlock()
+		if cancel != nil {
+			cancel()
+		}
+		<-drained
+		fmt.Fprintf(buf, "drained %d running queries, canceled %d: %v\n", running-left, left, ctx.Err())
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	var errs []string
+	for _, s := range t.Sinks {
+		c, ok := s.(io.Closer)
+		if !ok {
+			continue
+		}
+		if err := c.Close(); err != nil {
+			errs = append(errs, fmt.Sprintf("sink %T: %v", s, err))
+			continue
+		}
+		fmt.Fprintf(buf, "flushed sink %T\n", s)
+	}
+
+	if err := t.closeNode(buf); err != nil {
+		errs = append(errs, err.Error())
+	}
+	if len(errs) > 0 {
+		return buf, fmt.Errorf("stopping: %s", strings.Join(errs, "; "))
+	}
+	return buf, nil
+}
 
-	// if t.ctx.cancel != nil {
-	//   t.ctx.cancel()
-	//   t.ctx.cancel = nil
-	// }
+// closeNode cancels the node's queries, and closes it. t must be locked.
+func (t *Tracer) closeNode(w io.Writer) error {
+	t.activeMu.Lock()
+	cancel := t.ctx.cancel
+	t.ctx.cancel = nil
+	t.activeMu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	if t.Node == nil {
+		return nil
+	}
+	if err := t.Node.Close(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "closed node %v: dht, host and datastore\n", t.Node)
+	return nil
+}
+
+// Query is a dht query for the Tracer to run.
+type Query struct {
+	Cmd  Command
+	Key  Key
+	Args []string
+	Opts QueryOpts
 
-	// stop node
-	// delete node
+	// OnRecord, if set, is called with every better record search-value
+	// finds, as it finds them.
+	OnRecord func(SearchRecord)
+}
+
+// ParseQuery parses a query in the text format tracedht accepts,
+// e.g. "put-value <key> <value>" or "get-value --quorum=3 <key>".
+func ParseQuery(line string) (Query, error) {
+	cmd, args, err := parseCmd(line)
+	if err != nil {
+		return Query{}, err
+	}
+	return NewQuery(cmd, args)
+}
+
+// NewQuery makes the query of a command and its args, options included.
+// Unlike ParseQuery, args are taken as is.
+func NewQuery(cmd string, args []string) (Query, error) {
+	if !cmdInGroup(cmd, QueryCmds) {
+		return Query{}, fmt.Errorf("not a query command: %v", cmd)
+	}
+	opts, args, err := ParseQueryOpts(args)
+	if err != nil {
+		return Query{}, err
+	}
+	if len(args) < 1 {
+		return Query{}, fmt.Errorf("%v needs a key", cmd)
+	}
+	return Query{Cmd: cmd, Key: args[0], Args: args[1:], Opts: opts}, nil
+}
+
+// DecodedKey is the key, decoded from its Opts.KeyEncoding.
+func (q Query) DecodedKey() (string, error) {
+	k, err := Decode(q.Opts.KeyEncoding, q.Key)
+	if err != nil {
+		return "", fmt.Errorf("key: %w", err)
+	}
+	return string(k), nil
+}
+
+// DecodedValue is put-value's value, decoded from its Opts.ValueEncoding.
+func (q Query) DecodedValue() ([]byte, error) {
+	if len(q.Args) < 1 {
+		return nil, fmt.Errorf("%v needs a value", q.Cmd)
+	}
+	v, err := Decode(q.Opts.ValueEncoding, q.Args[0])
+	if err != nil {
+		return nil, fmt.Errorf("value: %w", err)
+	}
+	if len(v) > MaxValueSize {
+		return nil, fmt.Errorf("value is %d bytes, the most is %d", len(v), MaxValueSize)
+	}
+	return v, nil
+}
+
+// SetValue sets put-value's value to v, as is.
+func (q *Query) SetValue(v []byte) error {
+	switch {
+	case q.Cmd != CmdPutValue:
+		return fmt.Errorf("%v takes no value", q.Cmd)
+	case len(q.Args) > 0:
+		return errors.New("put-value was given two values")
+	case q.Opts.ValueEncoding != "":
+		return errors.New("a raw value takes no --value-encoding")
+	}
+	q.Args = []string{Encode(EncodingBase64, v)}
+	q.Opts.ValueEncoding = EncodingBase64
+	return nil
+}
+
+func (q Query) String() string {
+	s := []string{q.Cmd}
+	if o := q.Opts.String(); o != "" {
+		s = append(s, o)
+	}
+	return strings.Join(append(append(s, q.Key), q.Args...), " ")
+}
+
+// Result is the outcome of a query.
+type Result struct {
+	Query Query
+	Out   io.Reader // human readable output
+	Trace *dhtquery.Trace
+
+	// Value is the typed result, if the query has one: the value
+	// ([]byte) of get-value, the providers ([]peer.AddrInfo) of
+	// get-providers, the peer (peer.AddrInfo) of find-peer, the round
+	// trip time (time.Duration) of ping, the peers ([]ClosestPeer) of
+	// get-closest-peers, the records ([]SearchRecord) of search-value, and
+	// the record (IPNSRecord) of ipns-publish and ipns-resolve.
+	Value interface{}
 }
 
 func (t *Tracer) RunQuery(cmd Command, key Key, vals ...string) (io.Reader, error) {
+	res, err := t.Run(context.Background(), Query{Cmd: cmd, Key: key, Args: vals})
+	if err != nil {
+		return nil, err
+	}
+	return res.Out, nil
+}
+
+// Run runs and traces a query on the tracer's node. It is canceled when
+// either ctx is, or the node is stopped. Once the query ran, the result and
+// its trace are returned even if it failed.
+func (t *Tracer) Run(ctx context.Context, q Query) (*Result, error) {
+	if !t.enter() {
+		return nil, ErrStopping
+	}
+	defer t.running.Done()
+
 	t.RLock()
 	defer t.RUnlock()
 
-	ctx, cancel := context.WithCancel(t.ctx)
+	target, err := t.queryTarget(q)
+	if err != nil {
+		return nil, err
+	}
+	if err := q.Opts.Check(q.Cmd); err != nil {
+		return nil, err
+	}
+
+	var cancel context.CancelFunc
+	if q.Opts.Timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, q.Opts.Timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
 	defer cancel()
+	stop := context.AfterFunc(t.ctx, cancel)
+	defer stop()
+
+	ctx, trace := dhtquery.Record(ctx, t.Node.ID(), q.Cmd, q.Key, target, t.publishQueryEvent)
+	t.setActive(trace, true)
+	t.Events.Publish(queryStartEvent(q, trace))
+	out, val, err := t.runQuery(ctx, q, trace)
+	trace.Finish(err)
+	t.History.Add(trace)
+	t.setActive(trace, false)
+	t.Events.Publish(queryEndEvent(trace))
+	for _, s := range t.Sinks {
+		s.Add(trace)
+	}
+
+	res := &Result{Query: q, Out: strings.NewReader(out), Value: val, Trace: trace}
+	return res, err
+}
 
-	if len(key) < 1 {
-		return nil, fmt.Errorf("please enter a Key")
+// enter counts a query in, unless the tracer is stopping.
+func (t *Tracer) enter() bool {
+	t.activeMu.Lock()
+	defer t.activeMu.Unlock()
+	if t.stopping {
+		return false
 	}
+	t.running.Add(1)
+	return true
+}
 
-	// run query on node, return the result or closer peers.
-	switch cmd {
+func (t *Tracer) setActive(trace *dhtquery.Trace, active bool) {
+	t.activeMu.Lock()
+	defer t.activeMu.Unlock()
+	if active {
+		t.active[trace.ID] = trace
+	} else {
+		delete(t.active, trace.ID)
+	}
+}
+
+// Active returns the traces of the queries that are running.
+func (t *Tracer) Active() []*dhtquery.Trace {
+	t.activeMu.Lock()
+	defer t.activeMu.Unlock()
+
+	var ts []*dhtquery.Trace
+	for _, trace := range t.active {
+		ts = append(ts, trace)
+	}
+	sort.Slice(ts, func(i, j int) bool { return ts[i].Start.Before(ts[j].Start) })
+	return ts
+}
+
+// FindTrace returns the trace of a running or recent query.
+func (t *Tracer) FindTrace(id string) *dhtquery.Trace {
+	t.activeMu.Lock()
+	trace := t.active[id]
+	t.activeMu.Unlock()
+	if trace != nil {
+		return trace
+	}
+	return t.History.Get(id)
+}
+
+func (t *Tracer) publishQueryEvent(trace *dhtquery.Trace, now time.Time, e *routing.QueryEvent) {
+	t.Events.Publish(queryEvent(trace, now, e))
+}
+
+// queryTarget returns the key q looks up in the kad keyspace.
+func (t *Tracer) queryTarget(q Query) (string, error) {
+	if len(q.Key) < 1 {
+		return "", fmt.Errorf("please enter a Key")
+	}
+
+	switch q.Cmd {
+	case CmdAddProvider, CmdGetProviders:
+		c, err := cid.Decode(q.Key)
+		if err != nil {
+			return "", err
+		}
+		return string(c.Hash()), nil
+	case CmdFindPeer, CmdPing:
+		pid, err := peer.Decode(q.Key)
+		if err != nil {
+			return "", err
+		}
+		return string(pid), nil
 	case CmdPutValue:
-		if len(vals) < 1 {
-			return nil, fmt.Errorf("PutValue takes in 1 argument")
+		if _, err := q.DecodedValue(); err != nil {
+			return "", err
+		}
+		return q.DecodedKey()
+	case CmdIPNSPublish, CmdIPNSResolve:
+		return t.ipnsTarget(q)
+	default:
+		return q.DecodedKey()
+	}
+}
+
+// ClosestPeer is a peer that get-closest-peers returned, with its distance
+// to the key.
+type ClosestPeer struct {
+	Peer           peer.ID
+	XORDistance    string // hex, 64 digits, so that it sorts as the distance
+	BucketDistance int    // bit length of the xor distance (1-256)
+}
+
+func newClosestPeer(p peer.ID, target string) ClosestPeer {
+	a, b := kb.ConvertPeerID(p), kb.ConvertKey(target)
+	d := make([]byte, len(a))
+	for i := range d {
+		d[i] = a[i] ^ b[i]
+	}
+	return ClosestPeer{
+		Peer:           p,
+		XORDistance:    hex.EncodeToString(d),
+		BucketDistance: dhtquery.XORDistance(p, target),
+	}
+}
+
+// SearchRecord is a record search-value found, better than the ones before
+// it. kad-dht does not tell which peer supplied it, so LikelySupplier is a
+// guess from the responses traced so far, see
+// dhtquery.Trace.LikelyResponder. Empty if there is no guess.
+type SearchRecord struct {
+	Value          []byte
+	LikelySupplier peer.ID
+	Elapsed        time.Duration // since the query started
+}
+
+func (r SearchRecord) String() string {
+	return r.Text(EncodingText)
+}
+
+// Text formats the record with its value in enc.
+func (r SearchRecord) Text(enc string) string {
+	p := "unknown"
+	if r.LikelySupplier != "" {
+		p = r.LikelySupplier.String()
+	}
+	return fmt.Sprintf("%v likely:%s %dB %s", r.Elapsed.Round(time.Millisecond), p, len(r.Value), Encode(enc, r.Value))
+}
+
+func (t *Tracer) searchValue(ctx context.Context, q Query, trace *dhtquery.Trace) (string, interface{}, error) {
+	ch, err := t.Node.DHT.SearchValue(ctx, trace.Target, q.Opts.RoutingOptions()...)
+	if err != nil {
+		return "", nil, err
+	}
+	var recs []SearchRecord
+	var lines []string
+	for v := range ch {
+		at := time.Now()
+		r := SearchRecord{Value: v, LikelySupplier: trace.LikelyResponder(at), Elapsed: at.Sub(trace.Start)}
+		t.Events.Publish(searchRecordEvent(trace, at, r))
+		if q.OnRecord != nil {
+			q.OnRecord(r)
 		}
-		err := t.Node.DHT.PutValue(ctx, key, []byte(vals[0]))
+		recs = append(recs, r)
+		lines = append(lines, r.Text(q.Opts.Output))
+	}
+	if ctx.Err() != nil {
+		return "", nil, ctx.Err()
+	}
+	if len(recs) < 1 {
+		return "", nil, routing.ErrNotFound
+	}
+	return strings.Join(lines, "\n"), recs, nil
+}
+
+func (t *Tracer) runQuery(ctx context.Context, q Query, trace *dhtquery.Trace) (string, interface{}, error) {
+	key, vals, opts := q.Key, q.Args, q.Opts
+
+	// run query on node, return the result or closer peers.
+	switch q.Cmd {
+	case CmdPutValue:
+		val, _ := q.DecodedValue() // checked by queryTarget
+		err := t.Node.DHT.PutValue(ctx, trace.Target, val)
 		if err != nil {
-			return nil, err
+			return "", nil, err
 		}
-		s := fmt.Sprintf("put %v %v", key, vals[0])
-		return strings.NewReader(s), nil
+		return fmt.Sprintf("put %v %v (%d bytes)", key, vals[0], len(val)), nil, nil
 	case CmdGetValue:
-		val, err := t.Node.DHT.GetValue(ctx, key)
+		val, err := t.Node.DHT.GetValue(ctx, trace.Target, opts.RoutingOptions()...)
 		if err != nil {
-			return nil, err
+			return "", nil, err
 		}
-		return bytes.NewReader(val), nil
+		return Encode(opts.Output, val), val, nil
 	case CmdAddProvider:
-		c, err := cid.Decode(key)
+		c, _ := cid.Decode(key) // checked by queryTarget
+		err := t.Node.DHT.Provide(ctx, c, !opts.Offline)
 		if err != nil {
-			return nil, err
+			return "", nil, err
 		}
-		err = t.Node.DHT.Provide(ctx, c, true)
-		if err != nil {
-			return nil, err
+		if opts.Offline {
+			return fmt.Sprintf("added self as provider for %v, locally", key), nil, nil
 		}
-		s := fmt.Sprintf("added self as provider for %v", key)
-		return strings.NewReader(s), nil
+		return fmt.Sprintf("added self as provider for %v", key), nil, nil
 	case CmdGetProviders:
-		c, err := cid.Decode(key)
-		if err != nil {
-			return nil, err
-		}
-		pvs := t.Node.DHT.FindProvidersAsync(ctx, c, 10)
-		pr, pw := io.Pipe()
-		go func() {
-			for {
-				pv, ok := <-pvs // should respect context
-				if !ok {        // channel closed
-					return
+		c, _ := cid.Decode(key)
+		var pvs []peer.AddrInfo
+		var ids []string
+		if opts.Offline {
+			for _, p := range t.Node.DHT.ProviderManager.GetProviders(ctx, c.Hash()) {
+				if len(pvs) == opts.maxProviders() {
+					break
 				}
-				pw.Write([]byte(pv.ID.String())) // TODO handle write err?
+				pvs = append(pvs, t.Node.Host.Peerstore().PeerInfo(p))
+			}
+		} else {
+			for pv := range t.Node.DHT.FindProvidersAsync(ctx, c, opts.maxProviders()) {
+				pvs = append(pvs, pv)
 			}
-		}()
-		return pr, nil
+		}
+		for _, pv := range pvs {
+			ids = append(ids, pv.ID.String())
+		}
+		if ctx.Err() != nil {
+			return "", nil, ctx.Err()
+		}
+		if len(pvs) < 1 {
+			return "", nil, routing.ErrNotFound
+		}
+		return strings.Join(ids, "\n"), pvs, nil
 	case CmdFindPeer:
-		pid, err := peer.Decode(key)
-		if err != nil {
-			return nil, err
+		pid, _ := peer.Decode(key)
+		if opts.Offline {
+			ai := t.Node.DHT.FindLocal(pid)
+			if ai.ID == "" {
+				return "", nil, routing.ErrNotFound
+			}
+			return ai.String(), ai, nil
 		}
 		ai, err := t.Node.DHT.FindPeer(ctx, pid)
 		if err != nil {
-			return nil, err
+			return "", nil, err
 		}
-		return strings.NewReader(ai.String()), nil
+		return ai.String(), ai, nil
 	case CmdPing:
-		pid, err := peer.Decode(key)
-		if err != nil {
-			return nil, err
-		}
+		pid, _ := peer.Decode(key)
 		t1 := time.Now()
-		err = t.Node.DHT.Ping(ctx, pid)
+		err := t.Node.DHT.Ping(ctx, pid)
 		if err != nil {
-			return nil, err
+			return "", nil, err
 		}
 		d := time.Since(t1)
-		s := fmt.Sprintf("ping time: %v", d)
-		return strings.NewReader(s), nil
+		return fmt.Sprintf("ping time: %v", d), d, nil
+	case CmdGetClosestPeers:
+		pids, err := t.Node.DHT.GetClosestPeers(ctx, trace.Target)
+		if err != nil {
+			return "", nil, err
+		}
+		cps := make([]ClosestPeer, len(pids))
+		for i, p := range pids {
+			cps[i] = newClosestPeer(p, trace.Target)
+		}
+		sort.SliceStable(cps, func(i, j int) bool { return cps[i].XORDistance < cps[j].XORDistance })
+
+		lines := make([]string, len(cps))
+		for i, cp := range cps {
+			lines[i] = fmt.Sprintf("%v %s %d", cp.Peer, cp.XORDistance, cp.BucketDistance)
+		}
+		return strings.Join(lines, "\n"), cps, nil
+	case CmdSearchValue:
+		return t.searchValue(ctx, q, trace)
+	case CmdIPNSPublish:
+		return t.ipnsPublish(ctx, q, trace)
+	case CmdIPNSResolve:
+		return t.ipnsResolve(ctx, q, trace)
 	default:
-		return nil, fmt.Errorf("unknown command")
+		return "", nil, fmt.Errorf("unknown command")
 	}
 }
 
-func (t *Tracer) Reset() (io.Reader, error) {
-	// t.Stop()
-	err := t.Start()
+// RunWorkload populates the DHT with records from the tracer's node, then
+// reads them back, and returns a summary of the results.
+func (t *Tracer) RunWorkload(ctx context.Context, cfg workload.Cfg) (io.Reader, error) {
+	if !t.enter() {
+		return nil, ErrStopping
+	}
+	defer t.running.Done()
+
+	t.RLock()
+	defer t.RUnlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(t.ctx, cancel)
+	defer stop()
+
+	w := workload.New(cfg)
+	err := w.Run(ctx, []*dhtnode.Node{t.Node})
 	if err != nil {
 		return nil, err
 	}
-	return strings.NewReader("restarted"), nil
+
+	buf := bytes.NewBuffer(nil)
+	workload.PrintResults(buf, w.Results())
+	return buf, nil
+}
+
+// Reset replaces the tracer's node with a new one, once the running
+// queries finished.
+func (t *Tracer) Reset() (io.Reader, error) {
+	t.activeMu.Lock()
+	stopping := t.stopping
+	t.activeMu.Unlock()
+	if stopping {
+		return nil, ErrStopping
+	}
+
+	t.Lock()
+	defer t.Unlock()
+
+	// Stop may have begun while waiting for the lock
+	t.activeMu.Lock()
+	stopping = t.stopping
+	t.activeMu.Unlock()
+	if stopping {
+		return nil, ErrStopping
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := t.closeNode(buf); err != nil {
+		return nil, err
+	}
+	if err := t.start(); err != nil {
+		return nil, err
+	}
+	fmt.Fprintln(buf, "restarted")
+	return buf, nil
 }
 
 // func (t *Tracer) Repl(rw io.ReadWriter) {
@@ -0,0 +1,74 @@
+package dhttracer
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"unicode/utf8"
+)
+
+// Encodings of keys and values in text: queries, outputs and json.
+const (
+	EncodingText   = "text" // as is
+	EncodingBase64 = "base64"
+	EncodingHex    = "hex"
+)
+
+// MaxValueSize is the largest value put-value accepts, well below the
+// largest message kad-dht sends.
+var MaxValueSize = 1 << 20
+
+func checkEncoding(enc string) error {
+	switch enc {
+	case "", EncodingText, EncodingBase64, EncodingHex:
+		return nil
+	default:
+		return fmt.Errorf("unknown encoding: %v", enc)
+	}
+}
+
+// Decode decodes s from enc. An empty enc is text. base64 may also be url
+// safe, since '+' does not survive in urls.
+func Decode(enc, s string) ([]byte, error) {
+	switch enc {
+	case "", EncodingText:
+		return []byte(s), nil
+	case EncodingBase64:
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			if ub, uerr := base64.URLEncoding.DecodeString(s); uerr == nil {
+				return ub, nil
+			}
+		}
+		return b, err
+	case EncodingHex:
+		return hex.DecodeString(s)
+	default:
+		return nil, fmt.Errorf("unknown encoding: %v", enc)
+	}
+}
+
+// Encode encodes b in enc. An empty enc is text.
+func Encode(enc string, b []byte) string {
+	switch enc {
+	case EncodingBase64:
+		return base64.StdEncoding.EncodeToString(b)
+	case EncodingHex:
+		return hex.EncodeToString(b)
+	default:
+		return string(b)
+	}
+}
+
+// jsonValue encodes b for json, in enc. Binary values asked for as text
+// are sent as base64 instead, since json strings must be utf-8. It returns
+// the encoding used.
+func jsonValue(enc string, b []byte) (string, string) {
+	if enc == "" {
+		enc = EncodingText
+	}
+	if enc == EncodingText && !utf8.Valid(b) {
+		enc = EncodingBase64
+	}
+	return Encode(enc, b), enc
+}
@@ -0,0 +1,47 @@
+package dhttracer
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	cases := []struct {
+		enc  string
+		in   string
+		want []byte // nil for an error
+	}{
+		{"", "/v/foo", []byte("/v/foo")},
+		{EncodingText, "", []byte{}},
+		{EncodingHex, "00ff10", []byte{0x00, 0xff, 0x10}},
+		{EncodingHex, "0", nil},
+		{EncodingHex, "zz", nil},
+		{EncodingBase64, "aGk=", []byte("hi")},
+		{EncodingBase64, "+/8=", []byte{0xfb, 0xff}},
+		{EncodingBase64, "-_8=", []byte{0xfb, 0xff}}, // url safe
+		{EncodingBase64, "aGk", nil},                 // unpadded
+		{EncodingBase64, "+_8=", nil},                // mixed alphabets
+		{"base32", "aa", nil},
+	}
+	for _, c := range cases {
+		got, err := Decode(c.enc, c.in)
+		switch {
+		case c.want == nil && err == nil:
+			t.Errorf("Decode(%q, %q) = %x, want error", c.enc, c.in, got)
+		case c.want != nil && err != nil:
+			t.Errorf("Decode(%q, %q): %v", c.enc, c.in, err)
+		case c.want != nil && !bytes.Equal(got, c.want):
+			t.Errorf("Decode(%q, %q) = %x, want %x", c.enc, c.in, got, c.want)
+		}
+	}
+}
+
+func TestEncodeDecode(t *testing.T) {
+	b := []byte{0, 1, 0xfb, 0xff, 'a'}
+	for _, enc := range []string{EncodingText, EncodingBase64, EncodingHex} {
+		got, err := Decode(enc, Encode(enc, b))
+		if err != nil || !bytes.Equal(got, b) {
+			t.Errorf("%s: got %x, %v, want %x", enc, got, err, b)
+		}
+	}
+}
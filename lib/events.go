@@ -0,0 +1,269 @@
+package dhttracer
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	dhtquery "github.com/libp2p/dht-tracer1/lib/dhtquery"
+	network "github.com/libp2p/go-libp2p-core/network"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+)
+
+// Event types.
+const (
+	EventQueryStart   = "query-start"
+	EventQueryEnd     = "query-end"
+	EventDialing      = "dialing"
+	EventSendingQuery = "sending-query"
+	EventPeerResponse = "peer-response"
+	EventFinalPeer    = "final-peer"
+	EventQueryError   = "query-error"
+	EventProvider     = "provider"
+	EventValue        = "value"
+	EventAddingPeer   = "adding-peer"
+	EventRecord       = "record" // a better record search-value found
+	EventConnected    = "connected"
+	EventDisconnected = "disconnected"
+	EventHeartbeat    = "heartbeat"
+)
+
+// Event subsystems.
+const (
+	SubsystemQuery = "query" // queries starting and ending
+	SubsystemDHT   = "dht"   // kad-dht's events during a query
+	SubsystemNet   = "net"   // the node's connections
+)
+
+var queryEventTypes = map[routing.QueryEventType]string{
+	routing.SendingQuery: EventSendingQuery,
+	routing.PeerResponse: EventPeerResponse,
+	routing.FinalPeer:    EventFinalPeer,
+	routing.QueryError:   EventQueryError,
+	routing.Provider:     EventProvider,
+	routing.Value:        EventValue,
+	routing.AddingPeer:   EventAddingPeer,
+	routing.DialingPeer:  EventDialing,
+}
+
+// Event is a typed tracer event.
+type Event struct {
+	Time      time.Time              `json:"time"`
+	Type      string                 `json:"type"`
+	Subsystem string                 `json:"subsystem"`
+	QueryID   string                 `json:"query_id,omitempty"`
+	Peer      string                 `json:"peer,omitempty"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+}
+
+// EventFilter selects events. Empty fields match everything.
+type EventFilter struct {
+	QueryID    string
+	Peer       string
+	Types      []string
+	Subsystems []string
+}
+
+// Set sets a filter field by name: query, peer, type or subsystem. type and
+// subsystem take comma separated lists, and add to the ones set before.
+func (f *EventFilter) Set(key, val string) error {
+	switch key {
+	case "query":
+		f.QueryID = val
+	case "peer":
+		f.Peer = val
+	case "type":
+		f.Types = append(f.Types, strings.Split(val, ",")...)
+	case "subsystem":
+		f.Subsystems = append(f.Subsystems, strings.Split(val, ",")...)
+	default:
+		return fmt.Errorf("unknown event filter: %v", key)
+	}
+	return nil
+}
+
+func (f EventFilter) Match(e Event) bool {
+	switch {
+	case f.QueryID != "" && e.QueryID != f.QueryID:
+		return false
+	case f.Peer != "" && e.Peer != f.Peer:
+		return false
+	case len(f.Types) > 0 && !cmdInGroup(e.Type, f.Types):
+		return false
+	case len(f.Subsystems) > 0 && !cmdInGroup(e.Subsystem, f.Subsystems):
+		return false
+	}
+	return true
+}
+
+// Subscription receives the events matching its filter on C.
+type Subscription struct {
+	C <-chan Event
+
+	c       chan Event
+	filter  EventFilter
+	dropped int64 // atomic
+}
+
+// Dropped is how many events were dropped, because C was not read from
+// fast enough.
+func (s *Subscription) Dropped() int {
+	return int(atomic.LoadInt64(&s.dropped))
+}
+
+// EventBus fans events out to subscribers. Publishing never blocks: events
+// for subscribers that fall behind are dropped.
+type EventBus struct {
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subs: map[*Subscription]struct{}{}}
+}
+
+func (b *EventBus) Subscribe(f EventFilter, buf int) *Subscription {
+	c := make(chan Event, buf)
+	s := &Subscription{C: c, c: c, filter: f}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs[s] = struct{}{}
+	return s
+}
+
+// Unsubscribe stops delivery to s, and closes s.C.
+func (b *EventBus) Unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subs[s]; ok {
+		delete(b.subs, s)
+		close(s.c)
+	}
+}
+
+func (b *EventBus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for s := range b.subs {
+		if !s.filter.Match(e) {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			atomic.AddInt64(&s.dropped, 1)
+		}
+	}
+}
+
+// queryEvent converts a kad-dht event of query t.
+func queryEvent(t *dhtquery.Trace, now time.Time, qe *routing.QueryEvent) Event {
+	e := Event{
+		Time:      now,
+		Type:      queryEventTypes[qe.Type],
+		Subsystem: SubsystemDHT,
+		QueryID:   t.ID,
+	}
+	if qe.ID != "" {
+		e.Peer = qe.ID.String()
+	}
+	if e.Type == "" {
+		e.Type = fmt.Sprintf("unknown-%d", qe.Type)
+	}
+
+	data := map[string]interface{}{}
+	if len(qe.Responses) > 0 {
+		var ps []string
+		for _, ai := range qe.Responses {
+			ps = append(ps, ai.ID.String())
+		}
+		data["peers"] = ps
+	}
+	if qe.Extra != "" {
+		data["extra"] = qe.Extra
+	}
+	if len(data) > 0 {
+		e.Data = data
+	}
+	return e
+}
+
+func queryStartEvent(q Query, t *dhtquery.Trace) Event {
+	data := map[string]interface{}{
+		"cmd":  q.Cmd,
+		"key":  q.Key,
+		"args": q.Args,
+	}
+	if o := q.Opts.String(); o != "" {
+		data["opts"] = o
+	}
+	return Event{
+		Time:      t.Start,
+		Type:      EventQueryStart,
+		Subsystem: SubsystemQuery,
+		QueryID:   t.ID,
+		Data:      data,
+	}
+}
+
+func queryEndEvent(t *dhtquery.Trace) Event {
+	data := map[string]interface{}{
+		"duration_ms": float64(t.Duration()) / float64(time.Millisecond),
+		"messages":    t.Messages,
+		"peers":       len(t.Peers),
+	}
+	if t.Err != "" {
+		data["err"] = t.Err
+	}
+	return Event{
+		Time:      t.End,
+		Type:      EventQueryEnd,
+		Subsystem: SubsystemQuery,
+		QueryID:   t.ID,
+		Data:      data,
+	}
+}
+
+func searchRecordEvent(t *dhtquery.Trace, now time.Time, r SearchRecord) Event {
+	e := Event{
+		Time:      now,
+		Type:      EventRecord,
+		Subsystem: SubsystemDHT,
+		QueryID:   t.ID,
+		Data: map[string]interface{}{
+			"size":       len(r.Value),
+			"elapsed_ms": float64(r.Elapsed) / float64(time.Millisecond),
+		},
+	}
+	e.Data["value"], e.Data["encoding"] = jsonValue(EncodingText, r.Value)
+	if r.LikelySupplier != "" {
+		e.Data["likely_supplier"] = r.LikelySupplier.String()
+	}
+	return e
+}
+
+// netNotifiee publishes the node's connection events.
+func netNotifiee(b *EventBus) network.Notifiee {
+	publish := func(typ string, c network.Conn) {
+		b.Publish(Event{
+			Type:      typ,
+			Subsystem: SubsystemNet,
+			Peer:      c.RemotePeer().String(),
+			Data: map[string]interface{}{
+				"addr":      c.RemoteMultiaddr().String(),
+				"direction": c.Stat().Direction.String(),
+			},
+		})
+	}
+	return &network.NotifyBundle{
+		ConnectedF:    func(_ network.Network, c network.Conn) { publish(EventConnected, c) },
+		DisconnectedF: func(_ network.Network, c network.Conn) { publish(EventDisconnected, c) },
+	}
+}
@@ -0,0 +1,148 @@
+package dhttracer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	dhtnode "github.com/libp2p/dht-tracer1/lib/dhtnode"
+	stats "github.com/libp2p/dht-tracer1/lib/stats"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+)
+
+// Variant is a named kad-dht configuration to compare.
+type Variant struct {
+	Name   string
+	Params dhtnode.DhtParams
+}
+
+// CompareCfg describes an A/B comparison of dht configurations.
+type CompareCfg struct {
+	Base     dhtnode.NodeCfg // every variant's params are applied on top
+	Variants []Variant
+	Queries  []Query
+	Rounds   int           // times to run the query set
+	Settle   time.Duration // wait after bootstrapping, to fill routing tables
+	Timeout  time.Duration // per query
+	Sinks    []TraceSink   // added to every variant's tracer
+
+	// OnResult, if set, is called with every query result. It may be
+	// called concurrently.
+	OnResult func(v Variant, res *Result, err error)
+}
+
+// VariantResult is how a variant did on the query set.
+type VariantResult struct {
+	Variant  Variant
+	Queries  int
+	Failures int
+	Latency  stats.Dist // of successful queries
+	Messages stats.Dist // requests sent per query
+}
+
+func (r *VariantResult) SuccessRate() float64 {
+	if r.Queries == 0 {
+		return 0
+	}
+	return float64(r.Queries-r.Failures) / float64(r.Queries)
+}
+
+// Compare starts a tracer per variant, and runs the same queries through
+// each of them. Every query runs on all variants at once, so that they see
+// the same network conditions.
+func Compare(ctx context.Context, cfg CompareCfg) (_ []*VariantResult, err error) {
+	if len(cfg.Variants) < 1 || len(cfg.Queries) < 1 {
+		return nil, errors.New("compare needs at least one variant and one query")
+	}
+
+	tracers := make([]*Tracer, len(cfg.Variants))
+	results := make([]*VariantResult, len(cfg.Variants))
+	defer func() {
+		// stop, not just close, the tracers, so that the sinks are flushed.
+		sctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+		defer cancel()
+		for _, t := range tracers {
+			if t == nil {
+				continue
+			}
+			if _, serr := t.Stop(sctx); serr != nil && err == nil {
+				err = serr
+			}
+		}
+	}()
+
+	for i, v := range cfg.Variants {
+		nc := cfg.Base
+		nc.DhtOpts = append(append([]dht.Option{}, cfg.Base.DhtOpts...), v.Params.Options()...)
+
+		t := NewTracer(nc)
+		t.Sinks = cfg.Sinks
+		tracers[i] = t // stopped even if it fails to start
+		if err := t.Start(); err != nil {
+			return nil, fmt.Errorf("starting variant %s: %w", v.Name, err)
+		}
+		results[i] = &VariantResult{Variant: v}
+	}
+
+	select {
+	case <-time.After(cfg.Settle):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	for round := 0; round < cfg.Rounds; round++ {
+		for _, q := range cfg.Queries {
+			var wg sync.WaitGroup
+			for i := range tracers {
+				wg.Add(1)
+				go func(t *Tracer, r *VariantResult) {
+					defer wg.Done()
+					qctx, cancel := context.WithTimeout(ctx, cfg.Timeout)
+					defer cancel()
+
+					res, err := t.Run(qctx, q)
+					if cfg.OnResult != nil {
+						cfg.OnResult(r.Variant, res, err)
+					}
+					r.Queries++
+					if err != nil {
+						r.Failures++
+					}
+					if res == nil {
+						return
+					}
+					if err == nil {
+						r.Latency.AddDuration(res.Trace.Duration())
+					}
+					r.Messages.Add(float64(res.Trace.Messages))
+				}(tracers[i], results[i])
+			}
+			wg.Wait()
+
+			if ctx.Err() != nil {
+				return results, ctx.Err()
+			}
+		}
+	}
+	return results, nil
+}
+
+func PrintComparison(w io.Writer, rs []*VariantResult) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "variant\tparams\tqueries\tsuccess\tlatency-p50\tlatency-p90\tlatency-p99\tmessages-mean\tmessages-p90")
+	for _, r := range rs {
+		lat := r.Latency.Summary()
+		msgs := r.Messages.Summary()
+		d := func(v float64) time.Duration {
+			return time.Duration(v).Round(time.Millisecond)
+		}
+		fmt.Fprintf(tw, "%s\t%v\t%d\t%.1f%%\t%v\t%v\t%v\t%.1f\t%.0f\n",
+			r.Variant.Name, r.Variant.Params, r.Queries, 100*r.SuccessRate(),
+			d(lat.P50), d(lat.P90), d(lat.P99), msgs.Mean, msgs.P90)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,132 @@
+package experiment
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"sort"
+)
+
+// Assertion bounds the metrics matching a pattern, either absolutely, or
+// relative to a baseline run. Metric is a Metrics key, where '*' matches
+// any run of characters, e.g. "*.find-peer.latency-ms.p95".
+//
+//	{"metric": "*.find-peer.latency-ms.p95", "max-increase": 0.1}
+//	{"metric": "*.get-value.recall.mean", "min": 0.9}
+type Assertion struct {
+	Metric      string   `json:"metric"`
+	Min         *float64 `json:"min,omitempty"`
+	Max         *float64 `json:"max,omitempty"`
+	MaxIncrease *float64 `json:"max-increase,omitempty"` // fraction of the baseline value
+	MaxDecrease *float64 `json:"max-decrease,omitempty"` // fraction of the baseline value
+}
+
+func (a Assertion) relative() bool {
+	return a.MaxIncrease != nil || a.MaxDecrease != nil
+}
+
+// Check is the outcome of an assertion on one metric.
+type Check struct {
+	Assertion Assertion
+	Metric    string
+	Value     float64
+	Baseline  float64
+	Failure   string // empty if the check passed
+	Skipped   bool   // a relative check without a baseline
+}
+
+func (c Check) Passed() bool {
+	return c.Failure == ""
+}
+
+// Checks evaluates assertions against m. baseline may be nil, in which
+// case the relative parts of assertions are skipped. An assertion that
+// matches no metric fails.
+func Checks(m, baseline Metrics, as []Assertion) []Check {
+	var cs []Check
+	for _, a := range as {
+		keys, err := matchMetrics(m, a.Metric)
+		if err != nil || len(keys) == 0 {
+			cs = append(cs, Check{Assertion: a, Metric: a.Metric, Failure: "no such metric"})
+			continue
+		}
+		for _, k := range keys {
+			cs = append(cs, check(a, k, m, baseline))
+		}
+	}
+	return cs
+}
+
+func check(a Assertion, key string, m, baseline Metrics) Check {
+	c := Check{Assertion: a, Metric: key, Value: m[key]}
+
+	if a.Min != nil && c.Value < *a.Min {
+		c.Failure = fmt.Sprintf("%g < min %g", c.Value, *a.Min)
+		return c
+	}
+	if a.Max != nil && c.Value > *a.Max {
+		c.Failure = fmt.Sprintf("%g > max %g", c.Value, *a.Max)
+		return c
+	}
+	if !a.relative() {
+		return c
+	}
+
+	if baseline == nil {
+		c.Skipped = true
+		return c
+	}
+	b, ok := baseline[key]
+	if !ok {
+		c.Failure = "no baseline value"
+		return c
+	}
+	c.Baseline = b
+
+	if a.MaxIncrease != nil && c.Value > b*(1+*a.MaxIncrease) {
+		c.Failure = fmt.Sprintf("%g is more than %g%% above baseline %g", c.Value, 100**a.MaxIncrease, b)
+	}
+	if a.MaxDecrease != nil && c.Value < b*(1-*a.MaxDecrease) {
+		c.Failure = fmt.Sprintf("%g is more than %g%% below baseline %g", c.Value, 100**a.MaxDecrease, b)
+	}
+	return c
+}
+
+func matchMetrics(m Metrics, pattern string) ([]string, error) {
+	var keys []string
+	for k := range m {
+		ok, err := path.Match(pattern, k)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// PrintChecks prints every check, and returns how many failed.
+func PrintChecks(w io.Writer, cs []Check) int {
+	failed, skipped := 0, 0
+	for _, c := range cs {
+		if c.Skipped {
+			skipped++
+			fmt.Fprintf(w, "skip %s = %g: no baseline\n", c.Metric, c.Value)
+			continue
+		}
+		if c.Passed() {
+			fmt.Fprintf(w, "ok   %s = %g\n", c.Metric, c.Value)
+			continue
+		}
+		failed++
+		fmt.Fprintf(w, "FAIL %s: %s\n", c.Metric, c.Failure)
+	}
+	fmt.Fprintf(w, "%d/%d checks passed", len(cs)-failed-skipped, len(cs)-skipped)
+	if skipped > 0 {
+		fmt.Fprintf(w, ", %d skipped", skipped)
+	}
+	fmt.Fprintln(w)
+	return failed
+}
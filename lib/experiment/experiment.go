@@ -0,0 +1,369 @@
+// Package experiment runs declarative dht experiments: it builds a local
+// network, populates it, points tracers with different dht configurations
+// at it, runs a query set through each, and writes out the results.
+package experiment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	dhttracer "github.com/libp2p/dht-tracer1/lib"
+	dhtnode "github.com/libp2p/dht-tracer1/lib/dhtnode"
+	otlp "github.com/libp2p/dht-tracer1/lib/otlp"
+	workload "github.com/libp2p/dht-tracer1/lib/workload"
+	network "github.com/libp2p/go-libp2p-core/network"
+	peerstore "github.com/libp2p/go-libp2p-core/peerstore"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+)
+
+// defaultK is the kad-dht default bucket size, used for recall when a
+// tracer does not set one.
+const defaultK = 20
+
+// Run executes the experiment, writing its results into outDir, and
+// progress to log:
+//
+//	spec.json        the spec, with defaults filled in
+//	network.txt      network nodes, and which are adversaries
+//	workload.txt     workload results, if any
+//	comparison.txt   side-by-side tracer comparison
+//	metrics.json     flattened Metrics
+//	traces-<tracer>.jsonl  a dhtquery.Trace per query
+func Run(ctx context.Context, s *Spec, outDir string, log io.Writer) (Metrics, error) {
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return nil, err
+	}
+	if err := writeJSON(filepath.Join(outDir, "spec.json"), s); err != nil {
+		return nil, err
+	}
+
+	// network
+	fmt.Fprintf(log, "starting %d node %s network...\n", s.Network.Size, s.Network.Transport)
+	nodeCfg, err := baseNodeCfg(s.Network)
+	if err != nil {
+		return nil, err
+	}
+	netCfg := nodeCfg
+	params, err := dhtnode.ParseDhtParams(s.Network.Params)
+	if err != nil {
+		return nil, err
+	}
+	netCfg.DhtOpts = append([]dht.Option{dht.Mode(dht.ModeServer)}, params.Options()...)
+
+	net, err := dhtnode.NewNet(s.Network.Size, netCfg)
+	if err != nil {
+		return nil, err
+	}
+	closed := map[*dhtnode.Node]bool{}
+	defer func() {
+		for _, n := range net.Nodes {
+			if !closed[n] {
+				n.Close()
+			}
+		}
+	}()
+
+	err = net.BootstrapTopology(s.Network.Topology, s.Network.Degree)
+	if err != nil {
+		return nil, err
+	}
+
+	// workload
+	var w *workload.Workload
+	if s.Workload != nil {
+		fmt.Fprintf(log, "populating %d values, %d provider records...\n",
+			s.Workload.Values, s.Workload.Providers)
+		w = workload.New(workloadCfg(s.Workload))
+		err := w.Populate(ctx, net.Nodes)
+		if err == nil && w.Cfg.Reads > 0 {
+			err = w.Read(ctx, net.Nodes)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		buf := bytes.NewBuffer(nil)
+		workload.PrintResults(buf, w.Results())
+		err = os.WriteFile(filepath.Join(outDir, "workload.txt"), buf.Bytes(), 0644)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// adversaries
+	honest, adversaries := pickAdversaries(net.Nodes, s.Network.Adversaries)
+	for n, kind := range adversaries {
+		switch kind {
+		case AdversaryDead:
+			n.Close()
+			closed[n] = true
+		case AdversaryRefuse:
+			refuse(n)
+			if err := checkRefuses(ctx, honest[0], n); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := writeNetwork(filepath.Join(outDir, "network.txt"), net, adversaries); err != nil {
+		return nil, err
+	}
+
+	// tracers
+	queries, err := makeQueries(s.Queries, honest, w)
+	if err != nil {
+		return nil, err
+	}
+
+	var variants []dhttracer.Variant
+	ks := map[string]int{}
+	for _, t := range s.Tracers {
+		p, _ := dhtnode.ParseDhtParams(t.Params) // checked by LoadSpec
+		variants = append(variants, dhttracer.Variant{Name: t.Name, Params: p})
+		ks[t.Name] = defaultK
+		if p.BucketSize > 0 {
+			ks[t.Name] = p.BucketSize
+		}
+	}
+
+	traceFiles := map[string]*json.Encoder{}
+	for _, v := range variants {
+		f, err := os.Create(filepath.Join(outDir, "traces-"+v.Name+".jsonl"))
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		traceFiles[v.Name] = json.NewEncoder(f)
+	}
+
+	// recall is measured against the live, honest nodes: the best a
+	// lookup can do.
+	truthNet := &dhtnode.Net{Nodes: honest}
+	var mu sync.Mutex
+	byCmd := map[string]map[string]*queryStats{}
+	all := map[string]*queryStats{}
+	onResult := func(v dhttracer.Variant, res *dhttracer.Result, err error) {
+		if res == nil {
+			return
+		}
+		truth := truthNet.ClosestPeers(res.Trace.Target, ks[v.Name])
+
+		mu.Lock()
+		defer mu.Unlock()
+		if byCmd[v.Name] == nil {
+			byCmd[v.Name] = map[string]*queryStats{}
+			all[v.Name] = &queryStats{}
+		}
+		qs := byCmd[v.Name][res.Query.Cmd]
+		if qs == nil {
+			qs = &queryStats{}
+			byCmd[v.Name][res.Query.Cmd] = qs
+		}
+		qs.add(res, err, truth)
+		all[v.Name].add(res, err, truth)
+		traceFiles[v.Name].Encode(res.Trace)
+	}
+
+	tracerCfg := nodeCfg
+	tracerCfg.Bootstrap = dhtnode.GetAddrInfos(honest)
+
+	var sinks []dhttracer.TraceSink
+	if s.OTLP != "" {
+		e := otlp.NewExporter(s.OTLP, "dhtexp-"+s.Name)
+		defer e.Close()
+		sinks = append(sinks, e)
+	}
+
+	fmt.Fprintf(log, "running %d queries, %d rounds, through %d tracers...\n",
+		len(queries), s.Rounds, len(variants))
+	rs, err := dhttracer.Compare(ctx, dhttracer.CompareCfg{
+		Base:     tracerCfg,
+		Variants: variants,
+		Queries:  queries,
+		Rounds:   s.Rounds,
+		Settle:   time.Duration(s.Settle),
+		Timeout:  time.Duration(s.Timeout),
+		Sinks:    sinks,
+		OnResult: onResult,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	dhttracer.PrintComparison(buf, rs)
+	err = os.WriteFile(filepath.Join(outDir, "comparison.txt"), buf.Bytes(), 0644)
+	if err != nil {
+		return nil, err
+	}
+	log.Write(buf.Bytes())
+
+	m := Metrics{}
+	for name, cmds := range byCmd {
+		for cmd, qs := range cmds {
+			qs.metrics(m, name+"."+cmd, s.Metrics)
+		}
+		all[name].metrics(m, name+".all", s.Metrics)
+	}
+	return m, m.WriteFile(filepath.Join(outDir, "metrics.json"))
+}
+
+func baseNodeCfg(ns NetworkSpec) (dhtnode.NodeCfg, error) {
+	cfg := dhtnode.DefaultNodeCfg()
+	cfg.Bootstrap = nil
+	// off the public dht, so tracers and nodes may use any bucket size
+	cfg.ProtocolPrefix = dhtnode.LocalProtocolPrefix
+	if ns.Validators != "" {
+		v, err := dhtnode.ParseValidatorCfg(ns.Validators)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Validators = v
+	}
+
+	switch ns.Transport {
+	case TransportMock:
+		lat, err := dhtnode.NewLatencyModel(ns.Latency.Model,
+			time.Duration(ns.Latency.Min), time.Duration(ns.Latency.Max))
+		if err != nil {
+			return cfg, err
+		}
+		cfg.NewHost = dhtnode.NewMockNet(lat).NewHost
+	case TransportTCP:
+		cfg.Libp2pOpts = dhtnode.Libp2pOptionsTCP()
+	case TransportQUIC:
+		cfg.Libp2pOpts = dhtnode.Libp2pOptionsQUIC()
+	default:
+		return cfg, fmt.Errorf("unknown transport: %v", ns.Transport)
+	}
+	return cfg, nil
+}
+
+func workloadCfg(ws *WorkloadSpec) workload.Cfg {
+	c := workload.DefaultCfg()
+	c.Values = ws.Values
+	c.Providers = ws.Providers
+	c.Reads = ws.Reads
+	c.Rate = ws.Rate
+	if ws.Dist != "" {
+		c.Dist = ws.Dist
+	}
+	if ws.ZipfS > 0 {
+		c.ZipfS = ws.ZipfS
+	}
+	if ws.Concurrency > 0 {
+		c.Concurrency = ws.Concurrency
+	}
+	return c
+}
+
+// refuse makes n reset the dht streams it has open, and any new ones.
+func refuse(n *dhtnode.Node) {
+	n.Host.SetStreamHandler(n.Protocol, func(s network.Stream) {
+		s.Reset()
+	})
+	for _, c := range n.Host.Network().Conns() {
+		for _, s := range c.GetStreams() {
+			if s.Protocol() == n.Protocol {
+				s.Reset()
+			}
+		}
+	}
+}
+
+// checkRefuses returns an error if the refuse adversary n answers a dht
+// request of from.
+func checkRefuses(ctx context.Context, from, n *dhtnode.Node) error {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	from.Host.Peerstore().AddAddrs(n.ID(), n.Host.Addrs(), peerstore.TempAddrTTL)
+	if err := from.DHT.Ping(ctx, n.ID()); err == nil {
+		return fmt.Errorf("refuse adversary %v answered a dht request", n)
+	}
+	return nil
+}
+
+// pickAdversaries picks random nodes to turn into adversaries.
+func pickAdversaries(nodes []*dhtnode.Node, as []AdversarySpec) ([]*dhtnode.Node, map[*dhtnode.Node]string) {
+	adversaries := map[*dhtnode.Node]string{}
+	perm := rand.Perm(len(nodes))
+	for _, a := range as {
+		for i := 0; i < a.Count && len(perm) > 1; i++ {
+			adversaries[nodes[perm[0]]] = a.Kind
+			perm = perm[1:]
+		}
+	}
+
+	var honest []*dhtnode.Node
+	for _, i := range perm {
+		honest = append(honest, nodes[i])
+	}
+	return honest, adversaries
+}
+
+func makeQueries(qs QuerySpec, honest []*dhtnode.Node, w *workload.Workload) ([]dhttracer.Query, error) {
+	var queries []dhttracer.Query
+	for i := 0; i < qs.FindPeer; i++ {
+		n := honest[rand.Intn(len(honest))]
+		queries = append(queries, dhttracer.Query{Cmd: dhttracer.CmdFindPeer, Key: n.ID().String()})
+	}
+
+	if (qs.GetValue > 0 || qs.GetProviders > 0) && w == nil {
+		return nil, fmt.Errorf("get-value and get-providers queries need a workload")
+	}
+	for i := 0; i < qs.GetValue && len(w.Values) > 0; i++ {
+		k := w.Values[rand.Intn(len(w.Values))]
+		queries = append(queries, dhttracer.Query{Cmd: dhttracer.CmdGetValue, Key: k})
+	}
+	for i := 0; i < qs.GetProviders && len(w.Providers) > 0; i++ {
+		c := w.Providers[rand.Intn(len(w.Providers))]
+		queries = append(queries, dhttracer.Query{Cmd: dhttracer.CmdGetProviders, Key: c.String()})
+	}
+
+	for _, l := range qs.Lines {
+		q, err := dhttracer.ParseQuery(l)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, q)
+	}
+
+	if len(queries) < 1 {
+		return nil, fmt.Errorf("experiment has no queries")
+	}
+	return queries, nil
+}
+
+func writeNetwork(file string, net *dhtnode.Net, adversaries map[*dhtnode.Node]string) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fmt.Fprintf(f, "%d nodes, %d adversaries\n", len(net.Nodes), len(adversaries))
+	for i, n := range net.Nodes {
+		role := "honest"
+		if kind, ok := adversaries[n]; ok {
+			role = kind
+		}
+		fmt.Fprintf(f, "%d %v %s\n", i, n.ID(), role)
+	}
+	return nil
+}
+
+func writeJSON(file string, v interface{}) error {
+	buf, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(buf, '\n'), 0644)
+}
@@ -0,0 +1,39 @@
+package experiment
+
+import (
+	"context"
+	"testing"
+
+	dhtnode "github.com/libp2p/dht-tracer1/lib/dhtnode"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+)
+
+func TestRefuse(t *testing.T) {
+	cfg, err := baseNodeCfg(NetworkSpec{Transport: TransportMock})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.DhtOpts = []dht.Option{dht.Mode(dht.ModeServer)}
+	net, err := dhtnode.NewNet(3, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer net.Close()
+	if err := net.BootstrapTopology(dhtnode.TopologyRing, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	from, adversary, honest := net.Nodes[0], net.Nodes[1], net.Nodes[2]
+	if err := from.DHT.Ping(ctx, adversary.ID()); err != nil {
+		t.Fatalf("before refusing: %v", err)
+	}
+
+	refuse(adversary)
+	if err := checkRefuses(ctx, from, adversary); err != nil {
+		t.Error(err)
+	}
+	if err := from.DHT.Ping(ctx, honest.ID()); err != nil {
+		t.Errorf("honest node: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package experiment
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"time"
+
+	dhttracer "github.com/libp2p/dht-tracer1/lib"
+	dhtquery "github.com/libp2p/dht-tracer1/lib/dhtquery"
+	stats "github.com/libp2p/dht-tracer1/lib/stats"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Metrics are an experiment's flattened results, keyed by
+// "<tracer>.<command|all>.<metric>[.<stat>]", e.g.
+// "alpha3.find-peer.latency-ms.p95" or "alpha3.all.success".
+type Metrics map[string]float64
+
+func LoadMetrics(file string) (Metrics, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var m Metrics
+	if err := json.Unmarshal(buf, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return m, nil
+}
+
+func (m Metrics) WriteFile(file string) error {
+	buf, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, append(buf, '\n'), 0644)
+}
+
+func (m Metrics) Print(w io.Writer) {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s %g\n", k, m[k])
+	}
+}
+
+// queryStats accumulates the measurements of a group of queries.
+type queryStats struct {
+	Queries   int
+	Successes int
+	Latency   stats.Dist // of successful queries
+	Messages  stats.Dist
+	Recall    stats.Dist
+	Hops      stats.Dist
+}
+
+// add measures a query result. truth is the k closest peers to the query
+// target, which recall is measured against.
+func (s *queryStats) add(res *dhttracer.Result, err error, truth []peer.ID) {
+	s.Queries++
+	if err == nil {
+		s.Successes++
+		s.Latency.AddDuration(res.Trace.Duration())
+	}
+	s.Messages.Add(float64(res.Trace.Messages))
+	s.Recall.Add(recall(res.Trace, truth))
+	s.Hops.Add(float64(res.Trace.MaxHops()))
+}
+
+func (s *queryStats) metrics(m Metrics, prefix string, which []string) {
+	m[prefix+".queries"] = float64(s.Queries)
+	for _, w := range which {
+		switch w {
+		case MetricSuccess:
+			if s.Queries > 0 {
+				m[prefix+".success"] = float64(s.Successes) / float64(s.Queries)
+			}
+		case MetricLatency:
+			addSummary(m, prefix+".latency-ms", s.Latency.Summary(), float64(time.Millisecond))
+		case MetricMessages:
+			addSummary(m, prefix+".messages", s.Messages.Summary(), 1)
+		case MetricRecall:
+			addSummary(m, prefix+".recall", s.Recall.Summary(), 1)
+		case MetricHops:
+			addSummary(m, prefix+".hops", s.Hops.Summary(), 1)
+		}
+	}
+}
+
+func addSummary(m Metrics, prefix string, s stats.Summary, unit float64) {
+	if s.N == 0 {
+		return
+	}
+	m[prefix+".min"] = s.Min / unit
+	m[prefix+".mean"] = s.Mean / unit
+	m[prefix+".p50"] = s.P50 / unit
+	m[prefix+".p90"] = s.P90 / unit
+	m[prefix+".p95"] = s.P95 / unit
+	m[prefix+".p99"] = s.P99 / unit
+	m[prefix+".max"] = s.Max / unit
+}
+
+// recall is the fraction of truth that responded to the query.
+func recall(t *dhtquery.Trace, truth []peer.ID) float64 {
+	if len(truth) == 0 {
+		return 1
+	}
+	in := map[peer.ID]bool{}
+	for _, p := range t.Responded() {
+		in[p] = true
+	}
+	hits := 0
+	for _, p := range truth {
+		if in[p] {
+			hits++
+		}
+	}
+	return float64(hits) / float64(len(truth))
+}
@@ -0,0 +1,192 @@
+package experiment
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	dhtnode "github.com/libp2p/dht-tracer1/lib/dhtnode"
+	workload "github.com/libp2p/dht-tracer1/lib/workload"
+)
+
+const (
+	TransportMock = "mock"
+	TransportTCP  = "tcp"
+	TransportQUIC = "quic"
+)
+
+const (
+	AdversaryDead   = "dead"   // shut down after bootstrapping, stays in routing tables
+	AdversaryRefuse = "refuse" // stays connected, but resets every dht stream
+)
+
+const (
+	MetricSuccess  = "success"
+	MetricLatency  = "latency"
+	MetricMessages = "messages"
+	MetricRecall   = "recall"
+	MetricHops     = "hops"
+)
+
+var AllMetrics = []string{MetricSuccess, MetricLatency, MetricMessages, MetricRecall, MetricHops}
+
+// Duration is a time.Duration written as a string, like "10s", in specs.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	*d = Duration(v)
+	return err
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Spec describes an experiment: a local network, the tracers that query it,
+// the records to populate it with, the queries to run, and what to measure.
+type Spec struct {
+	Name     string        `json:"name"`
+	Network  NetworkSpec   `json:"network"`
+	Tracers  []TracerSpec  `json:"tracers"`
+	Workload *WorkloadSpec `json:"workload,omitempty"`
+	Queries  QuerySpec     `json:"queries"`
+	Metrics  []string      `json:"metrics,omitempty"` // default: AllMetrics
+	Rounds   int           `json:"rounds,omitempty"`  // default: 1
+	Settle   Duration      `json:"settle,omitempty"`  // wait after starting tracers. default: 5s
+	Timeout  Duration      `json:"timeout,omitempty"` // per query. default: 1m
+	OTLP     string        `json:"otlp,omitempty"`    // collector url to send query traces to
+
+	Assertions []Assertion `json:"assertions,omitempty"` // checked against the metrics
+}
+
+type NetworkSpec struct {
+	Size        int             `json:"size"`
+	Transport   string          `json:"transport,omitempty"`  // mock (default), tcp or quic
+	Topology    string          `json:"topology,omitempty"`   // bootstrappers (default), random or ring
+	Degree      int             `json:"degree,omitempty"`     // connections per node for random and ring
+	Latency     LatencySpec     `json:"latency,omitempty"`    // mock transport only
+	Params      string          `json:"params,omitempty"`     // dht params of the nodes, e.g. "bucket-size=10"
+	Validators  string          `json:"validators,omitempty"` // record namespaces, e.g. "ipns;v". see dhtnode.ParseValidatorCfg
+	Adversaries []AdversarySpec `json:"adversaries,omitempty"`
+}
+
+type LatencySpec struct {
+	Model string   `json:"model,omitempty"` // none, constant (min) or uniform
+	Min   Duration `json:"min,omitempty"`
+	Max   Duration `json:"max,omitempty"`
+}
+
+type AdversarySpec struct {
+	Kind  string `json:"kind"`
+	Count int    `json:"count"`
+}
+
+type TracerSpec struct {
+	Name   string `json:"name"`
+	Params string `json:"params,omitempty"` // dht params, e.g. "alpha=3,beta=5"
+}
+
+type WorkloadSpec struct {
+	Values      int     `json:"values"`
+	Providers   int     `json:"providers"`
+	Dist        string  `json:"dist,omitempty"`
+	ZipfS       float64 `json:"zipf-s,omitempty"`
+	Reads       int     `json:"reads,omitempty"`
+	Rate        float64 `json:"rate,omitempty"`
+	Concurrency int     `json:"concurrency,omitempty"`
+}
+
+// QuerySpec is the query set every tracer runs. Queries are generated with
+// targets sampled from the network and the workload's records.
+type QuerySpec struct {
+	FindPeer     int      `json:"find-peer,omitempty"`
+	GetValue     int      `json:"get-value,omitempty"`
+	GetProviders int      `json:"get-providers,omitempty"`
+	Lines        []string `json:"lines,omitempty"` // literal queries, e.g. "get-value /v/foo"
+}
+
+// LoadSpec reads a JSON spec file, and fills in defaults.
+func LoadSpec(file string) (*Spec, error) {
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var s Spec
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&s); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if err := s.setDefaults(); err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return &s, nil
+}
+
+func (s *Spec) setDefaults() error {
+	if s.Name == "" {
+		s.Name = "experiment"
+	}
+	if s.Network.Size < 2 {
+		return errors.New("network size must be at least 2")
+	}
+	if s.Network.Transport == "" {
+		s.Network.Transport = TransportMock
+	}
+	if s.Network.Degree < 1 {
+		s.Network.Degree = 3
+	}
+	if len(s.Tracers) < 1 {
+		s.Tracers = []TracerSpec{{Name: "default"}}
+	}
+	if s.Network.Validators != "" {
+		if _, err := dhtnode.ParseValidatorCfg(s.Network.Validators); err != nil {
+			return fmt.Errorf("validators: %w", err)
+		}
+	}
+	if s.Workload != nil {
+		if err := workload.CheckRate(s.Workload.Rate); err != nil {
+			return fmt.Errorf("workload: %w", err)
+		}
+	}
+	for i, t := range s.Tracers {
+		if t.Name == "" {
+			return fmt.Errorf("tracer %d has no name", i)
+		}
+		if _, err := dhtnode.ParseDhtParams(t.Params); err != nil {
+			return fmt.Errorf("tracer %s: %w", t.Name, err)
+		}
+	}
+	for _, a := range s.Network.Adversaries {
+		if a.Kind != AdversaryDead && a.Kind != AdversaryRefuse {
+			return fmt.Errorf("unknown adversary kind: %v", a.Kind)
+		}
+	}
+	for _, a := range s.Assertions {
+		if a.Metric == "" {
+			return errors.New("assertion without a metric")
+		}
+	}
+	if len(s.Metrics) < 1 {
+		s.Metrics = AllMetrics
+	}
+	if s.Rounds < 1 {
+		s.Rounds = 1
+	}
+	if s.Settle == 0 {
+		s.Settle = Duration(5 * time.Second)
+	}
+	if s.Timeout == 0 {
+		s.Timeout = Duration(time.Minute)
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package dhttracer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	ipns "github.com/ipfs/go-ipns"
+	ipns_pb "github.com/ipfs/go-ipns/pb"
+	dhtquery "github.com/libp2p/dht-tracer1/lib/dhtquery"
+	crypto "github.com/libp2p/go-libp2p-core/crypto"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+	record "github.com/libp2p/go-libp2p-record"
+)
+
+// Defaults of the records ipns-publish makes: how long they are valid, and
+// how long resolvers may cache them.
+var (
+	DefaultIPNSLifetime = 24 * time.Hour
+	DefaultIPNSTTL      = time.Hour
+)
+
+// IPNSRecord is an ipns record ipns-publish made, or ipns-resolve found.
+type IPNSRecord struct {
+	Name  peer.ID
+	Value string // the path the name points to
+	Seq   uint64
+	EOL   time.Time
+	TTL   time.Duration
+}
+
+func (r IPNSRecord) String() string {
+	return fmt.Sprintf("/ipns/%s -> %s seq=%d eol=%s ttl=%v",
+		r.Name, r.Value, r.Seq, r.EOL.UTC().Format(time.RFC3339), r.TTL)
+}
+
+// ipnsPath checks the path an ipns record points to: /ipfs/<cid>[/...],
+// /ipns/<name>[/...], or a bare cid, which stands for /ipfs/<cid>.
+func ipnsPath(p string) (string, error) {
+	if c, err := cid.Decode(p); err == nil {
+		return "/ipfs/" + c.String(), nil
+	}
+	parts := strings.SplitN(p, "/", 4) // "", namespace, root, rest
+	if len(parts) < 3 || parts[0] != "" || parts[2] == "" {
+		return "", fmt.Errorf("bad path: %q, want /ipfs/<cid> or /ipns/<name>", p)
+	}
+	switch parts[1] {
+	case "ipfs":
+		if _, err := cid.Decode(parts[2]); err != nil {
+			return "", fmt.Errorf("bad path: %q: %w", p, err)
+		}
+	case "ipns":
+	default:
+		return "", fmt.Errorf("bad path: %q, want /ipfs/<cid> or /ipns/<name>", p)
+	}
+	return p, nil
+}
+
+// checkIPNS returns an error if the node does not accept ipns records.
+func (t *Tracer) checkIPNS() error {
+	nsval, _ := t.Node.DHT.Validator.(record.NamespacedValidator)
+	if _, ok := nsval["ipns"].(ipns.Validator); !ok {
+		return errors.New("the node does not validate ipns records, see --validators")
+	}
+	return nil
+}
+
+// ipnsKey returns the private key named name: the node's own for SelfKey,
+// or one from the keystore, made if create.
+func (t *Tracer) ipnsKey(name string, create bool) (crypto.PrivKey, error) {
+	if name == SelfKey {
+		return t.Node.Host.Peerstore().PrivKey(t.Node.ID()), nil
+	}
+	if create {
+		return t.Keys.GetOrCreate(name)
+	}
+	return t.Keys.Get(name)
+}
+
+// ipnsName returns the peer id of an ipns name: <peer-id>,
+// /ipns/<peer-id>, or the name of a key.
+func (t *Tracer) ipnsName(name string) (peer.ID, error) {
+	s := strings.TrimPrefix(name, "/ipns/")
+	if pid, err := peer.Decode(s); err == nil {
+		return pid, nil
+	}
+	sk, err := t.ipnsKey(s, false)
+	if err != nil {
+		return "", fmt.Errorf("%q is neither a peer id nor a key: %w", name, err)
+	}
+	return peer.IDFromPrivateKey(sk)
+}
+
+// ipnsTarget returns the record key of an ipns query. ipns-publish makes
+// its key, if there is none.
+func (t *Tracer) ipnsTarget(q Query) (string, error) {
+	if err := t.checkIPNS(); err != nil {
+		return "", err
+	}
+	var pid peer.ID
+	switch q.Cmd {
+	case CmdIPNSPublish:
+		if len(q.Args) < 1 {
+			return "", fmt.Errorf("%v needs a path", q.Cmd)
+		}
+		if _, err := ipnsPath(q.Args[0]); err != nil {
+			return "", err
+		}
+		sk, err := t.ipnsKey(q.Key, true)
+		if err != nil {
+			return "", err
+		}
+		if pid, err = peer.IDFromPrivateKey(sk); err != nil {
+			return "", err
+		}
+	default:
+		var err error
+		if pid, err = t.ipnsName(q.Key); err != nil {
+			return "", err
+		}
+	}
+	return ipns.RecordKey(pid), nil
+}
+
+// nextIPNSSeq reserves the sequence number of the next record of pid: one
+// more than the highest of the last one published, and the current record
+// in the dht, as the node's datastore does not outlive the process.
+func (t *Tracer) nextIPNSSeq(ctx context.Context, pid peer.ID, key string) (uint64, error) {
+	var current *uint64
+	b, err := t.Node.DHT.GetValue(ctx, key)
+	switch {
+	case err == nil:
+		var e ipns_pb.IpnsEntry
+		if e.Unmarshal(b) == nil {
+			current = e.Sequence
+		}
+	case errors.Is(err, routing.ErrNotFound):
+	default:
+		return 0, fmt.Errorf("looking up the current record: %w", err)
+	}
+
+	t.activeMu.Lock()
+	defer t.activeMu.Unlock()
+	last, ok := t.ipnsSeqs[pid]
+	if current != nil && (!ok || *current > last) {
+		last, ok = *current, true
+	}
+	seq := uint64(0)
+	if ok {
+		seq = last + 1
+	}
+	t.ipnsSeqs[pid] = seq
+	return seq, nil
+}
+
+func (t *Tracer) ipnsPublish(ctx context.Context, q Query, trace *dhtquery.Trace) (string, interface{}, error) {
+	sk, err := t.ipnsKey(q.Key, false) // made by ipnsTarget
+	if err != nil {
+		return "", nil, err
+	}
+	pid, err := peer.IDFromPrivateKey(sk)
+	if err != nil {
+		return "", nil, err
+	}
+	path, _ := ipnsPath(q.Args[0]) // checked by ipnsTarget
+
+	seq, err := t.nextIPNSSeq(ctx, pid, trace.Target)
+	if err != nil {
+		return "", nil, err
+	}
+	r := IPNSRecord{
+		Name:  pid,
+		Value: path,
+		Seq:   seq,
+		EOL:   time.Now().Add(q.Opts.ipnsLifetime()),
+		TTL:   q.Opts.ipnsTTL(),
+	}
+	entry, err := ipns.Create(sk, []byte(r.Value), r.Seq, r.EOL)
+	if err != nil {
+		return "", nil, err
+	}
+	ttl := uint64(r.TTL)
+	entry.Ttl = &ttl
+	if err := ipns.EmbedPublicKey(sk.GetPublic(), entry); err != nil {
+		return "", nil, err
+	}
+	b, err := entry.Marshal()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if err := t.Node.DHT.PutValue(ctx, trace.Target, b); err != nil {
+		return "", nil, err
+	}
+	return "published " + r.String(), r, nil
+}
+
+func (t *Tracer) ipnsResolve(ctx context.Context, q Query, trace *dhtquery.Trace) (string, interface{}, error) {
+	b, err := t.Node.DHT.GetValue(ctx, trace.Target, q.Opts.RoutingOptions()...)
+	if err != nil {
+		return "", nil, err
+	}
+	// the dht validated it already. validate it again, to be explicit
+	// about what a resolver checks.
+	v := ipns.Validator{KeyBook: t.Node.Host.Peerstore()}
+	if err := v.Validate(trace.Target, b); err != nil {
+		return "", nil, fmt.Errorf("invalid ipns record: %w", err)
+	}
+	var e ipns_pb.IpnsEntry
+	if err := e.Unmarshal(b); err != nil {
+		return "", nil, err
+	}
+	eol, _ := ipns.GetEOL(&e) // checked by Validate
+
+	r := IPNSRecord{
+		Name:  peer.ID(strings.TrimPrefix(trace.Target, "/ipns/")),
+		Value: string(e.GetValue()),
+		Seq:   e.GetSequence(),
+		EOL:   eol,
+		TTL:   time.Duration(e.GetTtl()),
+	}
+	return r.String(), r, nil
+}
@@ -0,0 +1,108 @@
+package dhttracer
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	crypto "github.com/libp2p/go-libp2p-core/crypto"
+)
+
+// SelfKey names the node's own key, the one its peer id is made from. It
+// is not in the keystore, and changes when the tracer resets.
+const SelfKey = "self"
+
+// ErrNoSuchKey is returned for keys a Keystore does not have.
+var ErrNoSuchKey = errors.New("no such key")
+
+// Keystore holds the private keys ipns-publish signs records with, by name.
+// With a Dir, keys are files in it, and outlive the tracer.
+type Keystore struct {
+	Dir string // "" keeps keys in memory only
+
+	mu   sync.Mutex
+	keys map[string]crypto.PrivKey
+}
+
+func NewKeystore(dir string) *Keystore {
+	return &Keystore{Dir: dir, keys: map[string]crypto.PrivKey{}}
+}
+
+func checkKeyName(name string) error {
+	switch {
+	case name == "", name == SelfKey:
+		return fmt.Errorf("bad key name: %q", name)
+	case strings.ContainsAny(name, `/\`), strings.HasPrefix(name, "."):
+		return fmt.Errorf("key names must not have slashes or a leading dot: %q", name)
+	}
+	return nil
+}
+
+// Get returns the key named name, or ErrNoSuchKey.
+func (k *Keystore) Get(name string) (crypto.PrivKey, error) {
+	if err := checkKeyName(name); err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.get(name)
+}
+
+func (k *Keystore) get(name string) (crypto.PrivKey, error) {
+	if sk, ok := k.keys[name]; ok {
+		return sk, nil
+	}
+	if k.Dir == "" {
+		return nil, ErrNoSuchKey
+	}
+	b, err := os.ReadFile(filepath.Join(k.Dir, name))
+	if os.IsNotExist(err) {
+		return nil, ErrNoSuchKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	sk, err := crypto.UnmarshalPrivateKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %w", name, err)
+	}
+	k.keys[name] = sk
+	return sk, nil
+}
+
+// GetOrCreate returns the key named name, and makes an ed25519 key of that
+// name if there is none.
+func (k *Keystore) GetOrCreate(name string) (crypto.PrivKey, error) {
+	if err := checkKeyName(name); err != nil {
+		return nil, err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	sk, err := k.get(name)
+	if err != ErrNoSuchKey {
+		return sk, err
+	}
+	sk, _, err = crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if k.Dir != "" {
+		b, err := crypto.MarshalPrivateKey(sk)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(k.Dir, 0700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(filepath.Join(k.Dir, name), b, 0600); err != nil {
+			return nil, err
+		}
+	}
+	k.keys[name] = sk
+	return sk, nil
+}
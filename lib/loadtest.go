@@ -0,0 +1,231 @@
+package dhttracer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	stats "github.com/libp2p/dht-tracer1/lib/stats"
+	workload "github.com/libp2p/dht-tracer1/lib/workload"
+	routing "github.com/libp2p/go-libp2p-core/routing"
+	kb "github.com/libp2p/go-libp2p-kbucket"
+)
+
+// Error classes, as reported by ErrorClass.
+const (
+	ErrClassTimeout  = "timeout"
+	ErrClassCanceled = "canceled"
+	ErrClassNotFound = "not-found"
+	ErrClassNoPeers  = "no-peers"
+	ErrClassOther    = "other"
+)
+
+// ErrorClass buckets query errors into a few coarse classes.
+func ErrorClass(err error) string {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrClassTimeout
+	case errors.Is(err, context.Canceled), errors.Is(err, ErrStopping):
+		return ErrClassCanceled
+	case errors.Is(err, routing.ErrNotFound):
+		return ErrClassNotFound
+	case errors.Is(err, kb.ErrLookupFailure):
+		return ErrClassNoPeers
+	default:
+		return ErrClassOther
+	}
+}
+
+// LoadCfg describes a load test: Queries are issued round robin, by
+// Concurrency workers, until Duration has passed or Count queries ran.
+type LoadCfg struct {
+	Queries     []Query
+	Concurrency int
+	QPS         float64 // queries started per second. 0 for no limit
+	Duration    time.Duration
+	Count       int           // 0 for no limit
+	Timeout     time.Duration // per query. 0 for none
+}
+
+func DefaultLoadCfg() LoadCfg {
+	return LoadCfg{
+		Concurrency: 10,
+		Duration:    time.Minute,
+		Timeout:     time.Minute,
+	}
+}
+
+// Set sets a LoadCfg field by its option name: q (adds a query),
+// concurrency, qps, duration, count or timeout.
+func (c *LoadCfg) Set(key, val string) error {
+	var err error
+	switch key {
+	case "q":
+		var q Query
+		q, err = ParseQuery(val)
+		c.Queries = append(c.Queries, q)
+	case "concurrency":
+		c.Concurrency, err = strconv.Atoi(val)
+	case "qps":
+		if c.QPS, err = strconv.ParseFloat(val, 64); err == nil {
+			err = workload.CheckRate(c.QPS)
+		}
+	case "duration":
+		c.Duration, err = time.ParseDuration(val)
+	case "count":
+		c.Count, err = strconv.Atoi(val)
+	case "timeout":
+		if c.Timeout, err = time.ParseDuration(val); err == nil && c.Timeout < 0 {
+			err = errors.New("timeout must not be negative")
+		}
+	default:
+		return fmt.Errorf("unknown load test option: %v", key)
+	}
+	return err
+}
+
+// LoadResult summarizes a load test.
+type LoadResult struct {
+	Cfg     LoadCfg
+	Elapsed time.Duration
+	Queries int
+	Errors  map[string]int          // by ErrorClass
+	Latency map[Command]*stats.Dist // of successful queries, by command
+}
+
+func (r *LoadResult) Throughput() float64 {
+	return float64(r.Queries) / r.Elapsed.Seconds()
+}
+
+// LoadTest drives queries against the tracer's node, concurrently and at
+// the configured rate.
+func (t *Tracer) LoadTest(ctx context.Context, cfg LoadCfg) (*LoadResult, error) {
+	if len(cfg.Queries) < 1 {
+		return nil, errors.New("load test needs at least one query")
+	}
+	if err := workload.CheckRate(cfg.QPS); err != nil {
+		return nil, err
+	}
+	if cfg.Concurrency < 1 {
+		cfg.Concurrency = 1
+	}
+
+	r := &LoadResult{
+		Cfg:     cfg,
+		Errors:  map[string]int{},
+		Latency: map[Command]*stats.Dist{},
+	}
+	var mu sync.Mutex
+	record := func(q Query, d time.Duration, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		r.Queries++
+		if err != nil {
+			r.Errors[ErrorClass(err)]++
+			return
+		}
+		if r.Latency[q.Cmd] == nil {
+			r.Latency[q.Cmd] = &stats.Dist{}
+		}
+		r.Latency[q.Cmd].AddDuration(d)
+	}
+
+	if cfg.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Duration)
+		defer cancel()
+	}
+
+	// the feeder hands out queries, at QPS if set.
+	queries := make(chan Query)
+	go func() {
+		defer close(queries)
+
+		var tick <-chan time.Time
+		if cfg.QPS > 0 {
+			tk := time.NewTicker(time.Duration(float64(time.Second) / cfg.QPS))
+			defer tk.Stop()
+			tick = tk.C
+		}
+		for i := 0; cfg.Count == 0 || i < cfg.Count; i++ {
+			if tick != nil {
+				select {
+				case <-tick:
+				case <-ctx.Done():
+					return
+				}
+			}
+			select {
+			case queries <- cfg.Queries[i%len(cfg.Queries)]:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for q := range queries {
+				var qctx context.Context
+				var cancel context.CancelFunc
+				if cfg.Timeout > 0 {
+					qctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+				} else {
+					qctx, cancel = context.WithCancel(ctx)
+				}
+				t1 := time.Now()
+				_, err := t.Run(qctx, q)
+				cancel()
+
+				// queries cut short by the end of the test are not errors
+				if ctx.Err() != nil {
+					continue
+				}
+				record(q, time.Since(t1), err)
+			}
+		}()
+	}
+	wg.Wait()
+	r.Elapsed = time.Since(start)
+	return r, nil
+}
+
+func PrintLoadResult(w io.Writer, r *LoadResult) {
+	errs := 0
+	for _, n := range r.Errors {
+		errs += n
+	}
+
+	fmt.Fprintf(w, "load test: %d queries in %v (%.2f qps), %d errors\n",
+		r.Queries, r.Elapsed.Round(time.Millisecond), r.Throughput(), errs)
+	fmt.Fprintf(w, "concurrency=%d target-qps=%v timeout=%v\n",
+		r.Cfg.Concurrency, r.Cfg.QPS, r.Cfg.Timeout)
+
+	var cmds []string
+	for c := range r.Latency {
+		cmds = append(cmds, c)
+	}
+	sort.Strings(cmds)
+	for _, c := range cmds {
+		fmt.Fprintf(w, "%s latency %v\n", c, r.Latency[c].Summary().DurationString())
+	}
+
+	var classes []string
+	for c := range r.Errors {
+		classes = append(classes, c)
+	}
+	sort.Strings(classes)
+	for _, c := range classes {
+		fmt.Fprintf(w, "errors %s: %d\n", c, r.Errors[c])
+	}
+}
@@ -0,0 +1,275 @@
+package dhttracer
+
+import (
+	"net/http"
+	"strings"
+
+	dhtnode "github.com/libp2p/dht-tracer1/lib/dhtnode"
+	dhtquery "github.com/libp2p/dht-tracer1/lib/dhtquery"
+	dhtmetrics "github.com/libp2p/go-libp2p-kad-dht/metrics"
+	prometheus "github.com/prometheus/client_golang/prometheus"
+	promhttp "github.com/prometheus/client_golang/prometheus/promhttp"
+	view "go.opencensus.io/stats/view"
+)
+
+const metricsNamespace = "dhttracer"
+
+// QueryMetrics is a TraceSink that measures the queries a Tracer runs.
+type QueryMetrics struct {
+	queries      *prometheus.CounterVec
+	latency      *prometheus.HistogramVec
+	peers        *prometheus.HistogramVec
+	dialFailures *prometheus.CounterVec
+}
+
+func NewQueryMetrics() *QueryMetrics {
+	return &QueryMetrics{
+		queries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "queries_total",
+			Help:      "Queries run, by command and outcome.",
+		}, []string{"command", "outcome"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "query_duration_seconds",
+			Help:      "Query latency, by command.",
+			Buckets:   prometheus.ExponentialBuckets(0.01, 2, 14), // 10ms - 80s
+		}, []string{"command"}),
+		peers: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "query_peers_contacted",
+			Help:      "Peers contacted per query, by command.",
+			Buckets:   prometheus.ExponentialBuckets(1, 2, 10), // 1 - 512
+		}, []string{"command"}),
+		dialFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "dial_failures_total",
+			Help:      "Failed dials to peers during queries, by reason.",
+		}, []string{"reason"}),
+	}
+}
+
+func (m *QueryMetrics) Add(t *dhtquery.Trace) {
+	outcome := "ok"
+	if t.Err != "" {
+		outcome = traceErrClass(t.Err)
+	}
+	m.queries.WithLabelValues(t.Cmd, outcome).Inc()
+	m.latency.WithLabelValues(t.Cmd).Observe(t.Duration().Seconds())
+	m.peers.WithLabelValues(t.Cmd).Observe(float64(len(t.Peers)))
+
+	for _, p := range t.Peers {
+		for _, s := range p.Spans {
+			if s.Type == dhtquery.SpanDial && s.Err != "" {
+				m.dialFailures.WithLabelValues(dialFailureReason(s.Err)).Inc()
+			}
+		}
+	}
+}
+
+func (m *QueryMetrics) Describe(ch chan<- *prometheus.Desc) {
+	m.queries.Describe(ch)
+	m.latency.Describe(ch)
+	m.peers.Describe(ch)
+	m.dialFailures.Describe(ch)
+}
+
+func (m *QueryMetrics) Collect(ch chan<- prometheus.Metric) {
+	m.queries.Collect(ch)
+	m.latency.Collect(ch)
+	m.peers.Collect(ch)
+	m.dialFailures.Collect(ch)
+}
+
+// traceErrClass is ErrorClass for errors that were stored as text in a
+// trace.
+func traceErrClass(err string) string {
+	switch {
+	case strings.Contains(err, "deadline exceeded"):
+		return ErrClassTimeout
+	case strings.Contains(err, "context canceled"):
+		return ErrClassCanceled
+	case strings.Contains(err, "not found"):
+		return ErrClassNotFound
+	case strings.Contains(err, "failed to find any peer"):
+		return ErrClassNoPeers
+	default:
+		return ErrClassOther
+	}
+}
+
+// dialFailureReason buckets dial errors into a few reasons.
+func dialFailureReason(err string) string {
+	switch {
+	case strings.Contains(err, "no response"):
+		return "no-response"
+	case strings.Contains(err, "timeout"), strings.Contains(err, "deadline exceeded"):
+		return "timeout"
+	case strings.Contains(err, "context canceled"):
+		return "canceled"
+	case strings.Contains(err, "connection refused"):
+		return "refused"
+	case strings.Contains(err, "no addresses"), strings.Contains(err, "no good addresses"):
+		return "no-addresses"
+	case strings.Contains(err, "protocol"):
+		return "protocol"
+	case strings.Contains(err, "reset"):
+		return "reset"
+	default:
+		return "other"
+	}
+}
+
+// NodeCollector reports the state of dht nodes when scraped: connected
+// peers, routing table size, and stored records.
+type NodeCollector struct {
+	Nodes func() []*dhtnode.Node
+
+	connected    *prometheus.Desc
+	routingTable *prometheus.Desc
+	records      *prometheus.Desc
+}
+
+func NewNodeCollector(nodes func() []*dhtnode.Node) *NodeCollector {
+	node := []string{"node"}
+	return &NodeCollector{
+		Nodes: nodes,
+		connected: prometheus.NewDesc(metricsNamespace+"_connected_peers",
+			"Peers the node is connected to.", node, nil),
+		routingTable: prometheus.NewDesc(metricsNamespace+"_routing_table_peers",
+			"Peers in the node's routing table.", node, nil),
+		records: prometheus.NewDesc(metricsNamespace+"_stored_records",
+			"Records in the node's datastore, by kind.", []string{"node", "kind"}, nil),
+	}
+}
+
+func (c *NodeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.connected
+	ch <- c.routingTable
+	ch <- c.records
+}
+
+func (c *NodeCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, n := range c.Nodes() {
+		if n == nil {
+			continue
+		}
+		id := n.ID().String()
+		ch <- prometheus.MustNewConstMetric(c.connected, prometheus.GaugeValue,
+			float64(len(n.Host.Network().Peers())), id)
+		ch <- prometheus.MustNewConstMetric(c.routingTable, prometheus.GaugeValue,
+			float64(n.DHT.RoutingTable().Size()), id)
+
+		vals, provs, err := n.RecordCounts()
+		if err != nil {
+			ch <- prometheus.NewInvalidMetric(c.records, err)
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.records, prometheus.GaugeValue,
+			float64(vals), id, dhtnode.RecordValue)
+		ch <- prometheus.MustNewConstMetric(c.records, prometheus.GaugeValue,
+			float64(provs), id, dhtnode.RecordProvider)
+	}
+}
+
+// openCensusCollector bridges opencensus views, like the ones kad-dht
+// records its rpc metrics in, to prometheus. It is an unchecked collector:
+// the label sets of views are only known once they have data.
+type openCensusCollector struct {
+	views []*view.View
+}
+
+// NewOpenCensusCollector registers views with opencensus, and returns a
+// collector for them.
+func NewOpenCensusCollector(views ...*view.View) (prometheus.Collector, error) {
+	if err := view.Register(views...); err != nil {
+		return nil, err
+	}
+	return &openCensusCollector{views: views}, nil
+}
+
+func (c *openCensusCollector) Describe(ch chan<- *prometheus.Desc) {}
+
+func (c *openCensusCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, v := range c.views {
+		rows, err := view.RetrieveData(v.Name)
+		if err != nil {
+			continue
+		}
+
+		var labels []string
+		for _, k := range v.TagKeys {
+			labels = append(labels, promName(k.Name()))
+		}
+		desc := prometheus.NewDesc(promName(v.Name), v.Description, labels, nil)
+
+		for _, row := range rows {
+			tags := map[string]string{}
+			for _, t := range row.Tags {
+				tags[t.Key.Name()] = t.Value
+			}
+			values := make([]string, len(v.TagKeys))
+			for i, k := range v.TagKeys {
+				values[i] = tags[k.Name()]
+			}
+
+			var m prometheus.Metric
+			switch d := row.Data.(type) {
+			case *view.CountData:
+				m, err = prometheus.NewConstMetric(desc, prometheus.CounterValue, float64(d.Value), values...)
+			case *view.SumData:
+				m, err = prometheus.NewConstMetric(desc, prometheus.UntypedValue, d.Value, values...)
+			case *view.LastValueData:
+				m, err = prometheus.NewConstMetric(desc, prometheus.GaugeValue, d.Value, values...)
+			case *view.DistributionData:
+				buckets := map[float64]uint64{}
+				var cum uint64
+				for i, b := range v.Aggregation.Buckets {
+					cum += uint64(d.CountPerBucket[i])
+					buckets[b] = cum
+				}
+				m, err = prometheus.NewConstHistogram(desc, uint64(d.Count), d.Sum(), buckets, values...)
+			default:
+				continue
+			}
+			if err != nil {
+				m = prometheus.NewInvalidMetric(desc, err)
+			}
+			ch <- m
+		}
+	}
+}
+
+// promName turns an opencensus name, like
+// "libp2p.io/dht/kad/sent_messages", into a prometheus one.
+func promName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		default:
+			return '_'
+		}
+	}, s)
+}
+
+// MetricsHandler serves nodes' state and kad-dht's rpc metrics, plus
+// collectors, in the prometheus text format.
+func MetricsHandler(nodes func() []*dhtnode.Node, collectors ...prometheus.Collector) (http.Handler, error) {
+	reg := prometheus.NewRegistry()
+	oc, err := NewOpenCensusCollector(dhtmetrics.DefaultViews...)
+	if err != nil {
+		return nil, err
+	}
+	collectors = append(collectors,
+		NewNodeCollector(nodes),
+		oc,
+		prometheus.NewGoCollector(),
+	)
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{}), nil
+}
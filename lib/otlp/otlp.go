@@ -0,0 +1,304 @@
+// Package otlp exports dht query traces as OpenTelemetry traces, to a
+// collector speaking OTLP/HTTP with JSON encoding: a root span per query,
+// with a child span per dial and request sent to a peer.
+package otlp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	logging "github.com/ipfs/go-log"
+	dhtquery "github.com/libp2p/dht-tracer1/lib/dhtquery"
+)
+
+var log = logging.Logger("otlp")
+
+// DefaultEndpoint is where a local collector receives OTLP/HTTP traces.
+const DefaultEndpoint = "http://localhost:4318/v1/traces"
+
+const (
+	spanKindInternal = 1
+	spanKindClient   = 3
+	statusError      = 2
+)
+
+// Exporter sends traces to a collector in batches.
+type Exporter struct {
+	Endpoint string
+	Service  string            // service.name resource attribute
+	Headers  map[string]string // e.g. for collector auth
+	Client   *http.Client
+
+	BatchSize int
+	Interval  time.Duration // max time a trace waits to be sent
+
+	queue     chan *dhtquery.Trace
+	done      chan struct{}
+	closeOnce sync.Once
+	mu        sync.Mutex
+	dropped   int
+}
+
+// NewExporter starts an exporter. Close must be called to send the traces
+// still queued.
+func NewExporter(endpoint, service string) *Exporter {
+	e := &Exporter{
+		Endpoint:  endpoint,
+		Service:   service,
+		Client:    &http.Client{Timeout: 10 * time.Second},
+		BatchSize: 64,
+		Interval:  5 * time.Second,
+		queue:     make(chan *dhtquery.Trace, 1024),
+		done:      make(chan struct{}),
+	}
+	go e.loop()
+	return e
+}
+
+// Add queues a finished trace for export. If the queue is full, the trace
+// is dropped.
+func (e *Exporter) Add(t *dhtquery.Trace) {
+	select {
+	case e.queue <- t:
+	default:
+		e.mu.Lock()
+		e.dropped++
+		e.mu.Unlock()
+	}
+}
+
+// Dropped is how many traces were dropped, because the queue was full.
+func (e *Exporter) Dropped() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.dropped
+}
+
+// Close sends the queued traces, and stops the exporter. Closing it again
+// does nothing.
+func (e *Exporter) Close() error {
+	e.closeOnce.Do(func() { close(e.queue) })
+	<-e.done
+	return nil
+}
+
+func (e *Exporter) loop() {
+	defer close(e.done)
+
+	tick := time.NewTicker(e.Interval)
+	defer tick.Stop()
+
+	var batch []*dhtquery.Trace
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.Export(context.Background(), batch...); err != nil {
+			log.Error("exporting traces:", err)
+		}
+		batch = nil
+	}
+
+	for {
+		select {
+		case t, ok := <-e.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, t)
+			if len(batch) >= e.BatchSize {
+				flush()
+			}
+		case <-tick.C:
+			flush()
+		}
+	}
+}
+
+// Export sends traces to the collector right away.
+func (e *Exporter) Export(ctx context.Context, ts ...*dhtquery.Trace) error {
+	buf, err := json.Marshal(e.request(ts))
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.Headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := e.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("collector returned %s: %s", res.Status, body)
+	}
+	return nil
+}
+
+// The types below are the JSON encoding of the OTLP
+// ExportTraceServiceRequest message.
+
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []attribute `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope  `json:"scope"`
+	Spans []span `json:"spans"`
+}
+
+type scope struct {
+	Name string `json:"name"`
+}
+
+type span struct {
+	TraceID           string      `json:"traceId"`
+	SpanID            string      `json:"spanId"`
+	ParentSpanID      string      `json:"parentSpanId,omitempty"`
+	Name              string      `json:"name"`
+	Kind              int         `json:"kind"`
+	StartTimeUnixNano string      `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string      `json:"endTimeUnixNano"`
+	Attributes        []attribute `json:"attributes,omitempty"`
+	Status            *status     `json:"status,omitempty"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type attribute struct {
+	Key   string `json:"key"`
+	Value value  `json:"value"`
+}
+
+type value struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"` // int64s are strings in OTLP JSON
+}
+
+func str(k, v string) attribute {
+	return attribute{Key: k, Value: value{StringValue: &v}}
+}
+
+func num(k string, v int) attribute {
+	s := strconv.Itoa(v)
+	return attribute{Key: k, Value: value{IntValue: &s}}
+}
+
+func nanos(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func errStatus(err string) *status {
+	if err == "" {
+		return nil
+	}
+	return &status{Code: statusError, Message: err}
+}
+
+func (e *Exporter) request(ts []*dhtquery.Trace) exportRequest {
+	var spans []span
+	for _, t := range ts {
+		spans = append(spans, querySpans(t)...)
+	}
+	return exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: []attribute{str("service.name", e.Service)}},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: "dht-tracer"}, Spans: spans}},
+	}}}
+}
+
+// querySpans converts a trace into spans. The trace and span ids are
+// derived from the query's id, so that exporting a query twice does not
+// duplicate it. Spans the query did not wait for end with it.
+func querySpans(t *dhtquery.Trace) []span {
+	sum := sha256.Sum256([]byte(t.ID))
+	traceID := hex.EncodeToString(sum[:16])
+	rootID := hex.EncodeToString(sum[16:24])
+
+	root := span{
+		TraceID:           traceID,
+		SpanID:            rootID,
+		Name:              "dht." + t.Cmd,
+		Kind:              spanKindInternal,
+		StartTimeUnixNano: nanos(t.Start),
+		EndTimeUnixNano:   nanos(t.End),
+		Attributes: []attribute{
+			str("dht.query.id", t.ID),
+			str("dht.command", t.Cmd),
+			str("dht.key", t.Key),
+			str("dht.self", t.Self.String()),
+			num("dht.messages", t.Messages),
+			num("dht.peers", len(t.Peers)),
+		},
+		Status: errStatus(t.Err),
+	}
+	spans := []span{root}
+
+	for _, p := range t.Peers {
+		attrs := []attribute{
+			str("peer.id", p.Peer.String()),
+			num("dht.xor_distance", p.XORDistance),
+			num("dht.hops", p.Hops),
+			num("dht.order", p.Order),
+		}
+		for i, s := range p.Spans {
+			a := attrs
+			if s.Type == dhtquery.SpanRequest && p.Responded {
+				a = append(a[:len(a):len(a)],
+					num("dht.closer_peers", len(p.CloserPeers)),
+					num("dht.closer_peers_new", p.CloserPeersNew))
+			}
+			end := s.End
+			if end.IsZero() {
+				end = t.End
+			}
+			spans = append(spans, span{
+				TraceID:           traceID,
+				SpanID:            spanID(t.ID, p.Peer.String(), i),
+				ParentSpanID:      rootID,
+				Name:              "dht." + s.Type,
+				Kind:              spanKindClient,
+				StartTimeUnixNano: nanos(s.Start),
+				EndTimeUnixNano:   nanos(end),
+				Attributes:        a,
+				Status:            errStatus(s.Err),
+			})
+		}
+	}
+	return spans
+}
+
+// spanID is the id of the i-th span of a peer in a query.
+func spanID(queryID, peer string, i int) string {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s/%s/%d", queryID, peer, i)))
+	return hex.EncodeToString(sum[:8])
+}
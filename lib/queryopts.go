@@ -0,0 +1,207 @@
+package dhttracer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	routing "github.com/libp2p/go-libp2p-core/routing"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+)
+
+// DefaultMaxProviders is how many providers get-providers looks for, unless
+// told otherwise.
+var DefaultMaxProviders = 10
+
+// QueryOpts are per-query options. In text, they are --name[=value]
+// arguments before the key, e.g. "get-value --quorum=3 --timeout=10s /v/foo".
+type QueryOpts struct {
+	Quorum       int           // responses get-value and search-value wait for. 0 for kad-dht's default
+	Offline      bool          // only use the node's datastore, provider store and peerstore
+	Timeout      time.Duration // 0 for none
+	MaxProviders int           // providers get-providers looks for. 0 for DefaultMaxProviders
+
+	// Of the records ipns-publish makes: how long they are valid, and how
+	// long resolvers may cache them. 0 for DefaultIPNSLifetime and
+	// DefaultIPNSTTL.
+	Lifetime time.Duration
+	TTL      time.Duration
+
+	// Encodings of the key, put-value's value, and the values in the
+	// output. See Decode. Empty is text.
+	KeyEncoding   string
+	ValueEncoding string
+	Output        string
+}
+
+// queryOptCmds lists the commands each option applies to. timeout applies
+// to all.
+var queryOptCmds = map[string][]string{
+	"quorum":        {CmdGetValue, CmdSearchValue, CmdIPNSResolve},
+	"offline":       {CmdGetValue, CmdSearchValue, CmdAddProvider, CmdGetProviders, CmdFindPeer, CmdIPNSResolve},
+	"max-providers": {CmdGetProviders},
+	"lifetime":      {CmdIPNSPublish},
+	"ttl":           {CmdIPNSPublish},
+
+	"key-encoding":   {CmdPutValue, CmdGetValue, CmdSearchValue, CmdGetClosestPeers},
+	"value-encoding": {CmdPutValue},
+	"output":         {CmdGetValue, CmdSearchValue},
+}
+
+// Set sets an option by name: quorum, offline, timeout, max-providers,
+// lifetime, ttl, key-encoding, value-encoding or output. offline takes an optional bool,
+// and is true without one. Encodings are text, base64 or hex.
+func (o *QueryOpts) Set(key, val string) error {
+	var err error
+	switch key {
+	case "quorum":
+		o.Quorum, err = strconv.Atoi(val)
+	case "offline":
+		o.Offline = true
+		if val != "" {
+			o.Offline, err = strconv.ParseBool(val)
+		}
+	case "timeout":
+		o.Timeout, err = time.ParseDuration(val)
+	case "max-providers":
+		o.MaxProviders, err = strconv.Atoi(val)
+	case "lifetime":
+		o.Lifetime, err = time.ParseDuration(val)
+	case "ttl":
+		o.TTL, err = time.ParseDuration(val)
+	case "key-encoding":
+		o.KeyEncoding, err = val, checkEncoding(val)
+	case "value-encoding":
+		o.ValueEncoding, err = val, checkEncoding(val)
+	case "output":
+		o.Output, err = val, checkEncoding(val)
+	default:
+		return fmt.Errorf("unknown query option: %v", key)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", key, err)
+	}
+	return nil
+}
+
+// ParseQueryOpts takes the --name[=value] options out of args, and returns
+// them and the remaining args.
+func ParseQueryOpts(args []string) (QueryOpts, []string, error) {
+	var o QueryOpts
+	var rest []string
+	for i, a := range args {
+		if a == "--" { // the rest are not options
+			rest = append(rest, args[i+1:]...)
+			break
+		}
+		if !strings.HasPrefix(a, "--") {
+			rest = append(rest, a)
+			continue
+		}
+		kv := strings.SplitN(strings.TrimPrefix(a, "--"), "=", 2)
+		val := ""
+		if len(kv) == 2 {
+			val = kv[1]
+		}
+		if err := o.Set(kv[0], val); err != nil {
+			return o, nil, err
+		}
+	}
+	return o, rest, nil
+}
+
+// Check returns an error if an option that is set does not apply to cmd.
+func (o QueryOpts) Check(cmd string) error {
+	set := map[string]bool{
+		"quorum":        o.Quorum != 0,
+		"offline":       o.Offline,
+		"max-providers": o.MaxProviders != 0,
+		"lifetime":      o.Lifetime != 0,
+		"ttl":           o.TTL != 0,
+
+		"key-encoding":   o.KeyEncoding != "",
+		"value-encoding": o.ValueEncoding != "",
+		"output":         o.Output != "",
+	}
+	for name, cmds := range queryOptCmds {
+		if set[name] && !cmdInGroup(cmd, cmds) {
+			return fmt.Errorf("%v does not take --%s", cmd, name)
+		}
+	}
+	if o.Quorum < 0 || o.MaxProviders < 0 || o.Timeout < 0 || o.Lifetime < 0 || o.TTL < 0 {
+		return fmt.Errorf("query options must not be negative")
+	}
+	for _, enc := range []string{o.KeyEncoding, o.ValueEncoding, o.Output} {
+		if err := checkEncoding(enc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RoutingOptions returns the routing.Options of a value query.
+func (o QueryOpts) RoutingOptions() []routing.Option {
+	var opts []routing.Option
+	if o.Quorum > 0 {
+		opts = append(opts, dht.Quorum(o.Quorum))
+	}
+	if o.Offline {
+		opts = append(opts, routing.Offline)
+	}
+	return opts
+}
+
+func (o QueryOpts) maxProviders() int {
+	if o.MaxProviders > 0 {
+		return o.MaxProviders
+	}
+	return DefaultMaxProviders
+}
+
+func (o QueryOpts) ipnsLifetime() time.Duration {
+	if o.Lifetime > 0 {
+		return o.Lifetime
+	}
+	return DefaultIPNSLifetime
+}
+
+func (o QueryOpts) ipnsTTL() time.Duration {
+	if o.TTL > 0 {
+		return o.TTL
+	}
+	return DefaultIPNSTTL
+}
+
+// String formats the options that are set, as ParseQueryOpts reads them.
+func (o QueryOpts) String() string {
+	var s []string
+	if o.Quorum != 0 {
+		s = append(s, fmt.Sprintf("--quorum=%d", o.Quorum))
+	}
+	if o.Offline {
+		s = append(s, "--offline")
+	}
+	if o.Timeout != 0 {
+		s = append(s, fmt.Sprintf("--timeout=%v", o.Timeout))
+	}
+	if o.MaxProviders != 0 {
+		s = append(s, fmt.Sprintf("--max-providers=%d", o.MaxProviders))
+	}
+	if o.Lifetime != 0 {
+		s = append(s, fmt.Sprintf("--lifetime=%v", o.Lifetime))
+	}
+	if o.TTL != 0 {
+		s = append(s, fmt.Sprintf("--ttl=%v", o.TTL))
+	}
+	if o.KeyEncoding != "" {
+		s = append(s, "--key-encoding="+o.KeyEncoding)
+	}
+	if o.ValueEncoding != "" {
+		s = append(s, "--value-encoding="+o.ValueEncoding)
+	}
+	if o.Output != "" {
+		s = append(s, "--output="+o.Output)
+	}
+	return strings.Join(s, " ")
+}
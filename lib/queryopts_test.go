@@ -0,0 +1,104 @@
+package dhttracer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQueryOpts(t *testing.T) {
+	cases := []struct {
+		args []string
+		opts QueryOpts
+		rest []string
+		err  string // start of the error, if any
+	}{
+		{args: []string{"/v/foo"}, rest: []string{"/v/foo"}},
+		{args: nil},
+		{
+			args: []string{"--quorum=3", "--timeout=10s", "/v/foo"},
+			opts: QueryOpts{Quorum: 3, Timeout: 10 * time.Second},
+			rest: []string{"/v/foo"},
+		},
+		{
+			args: []string{"/v/foo", "--offline"}, // options may follow the key
+			opts: QueryOpts{Offline: true},
+			rest: []string{"/v/foo"},
+		},
+		{args: []string{"--offline=false", "k"}, rest: []string{"k"}},
+		{
+			args: []string{"--key-encoding=hex", "--", "--not-an-option", "--"},
+			opts: QueryOpts{KeyEncoding: EncodingHex},
+			rest: []string{"--not-an-option", "--"},
+		},
+		{args: []string{"--", "--quorum=3"}, rest: []string{"--quorum=3"}},
+		{args: []string{"-quorum=3"}, rest: []string{"-quorum=3"}},
+
+		{args: []string{"--quorum"}, err: "quorum: "},
+		{args: []string{"--quorum=x"}, err: "quorum: "},
+		{args: []string{"--offline=maybe"}, err: "offline: "},
+		{args: []string{"--output=base32"}, err: "output: unknown encoding"},
+		{args: []string{"--frobnicate", "k"}, err: "unknown query option: frobnicate"},
+	}
+	for _, c := range cases {
+		opts, rest, err := ParseQueryOpts(c.args)
+		switch {
+		case c.err != "" && err == nil:
+			t.Errorf("%q: got %v %q, want error %q", c.args, opts, rest, c.err)
+		case c.err != "" && !strings.HasPrefix(err.Error(), c.err):
+			t.Errorf("%q: got error %q, want %q", c.args, err, c.err)
+		case c.err == "" && err != nil:
+			t.Errorf("%q: %v", c.args, err)
+		case c.err == "" && (opts != c.opts || !reflect.DeepEqual(rest, c.rest)):
+			t.Errorf("%q: got %+v %q, want %+v %q", c.args, opts, rest, c.opts, c.rest)
+		}
+	}
+}
+
+func TestQueryOptsString(t *testing.T) {
+	args := []string{"--quorum=2", "--offline", "--timeout=1m0s", "--output=hex"}
+	opts, _, err := ParseQueryOpts(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := opts.String(), strings.Join(args, " "); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestQueryOptsCheck(t *testing.T) {
+	cases := []struct {
+		cmd  string
+		opts QueryOpts
+		err  string // start of the error, if any
+	}{
+		{CmdGetValue, QueryOpts{}, ""},
+		{CmdPing, QueryOpts{Timeout: time.Second}, ""}, // timeout applies to all
+		{CmdGetValue, QueryOpts{Quorum: 3, Offline: true, Output: EncodingHex}, ""},
+		{CmdPutValue, QueryOpts{KeyEncoding: EncodingHex, ValueEncoding: EncodingBase64}, ""},
+		{CmdGetProviders, QueryOpts{MaxProviders: 5, Offline: true}, ""},
+		{CmdIPNSPublish, QueryOpts{Lifetime: time.Hour, TTL: time.Minute}, ""},
+
+		{CmdPutValue, QueryOpts{Quorum: 3}, "put-value does not take --quorum"},
+		{CmdPutValue, QueryOpts{Offline: true}, "put-value does not take --offline"},
+		{CmdGetValue, QueryOpts{MaxProviders: 5}, "get-value does not take --max-providers"},
+		{CmdGetValue, QueryOpts{ValueEncoding: EncodingHex}, "get-value does not take --value-encoding"},
+		{CmdPing, QueryOpts{Output: EncodingHex}, "ping does not take --output"},
+		{CmdIPNSResolve, QueryOpts{TTL: time.Minute}, "ipns-resolve does not take --ttl"},
+		{CmdGetValue, QueryOpts{Quorum: -1}, "query options must not be negative"},
+		{CmdGetValue, QueryOpts{Timeout: -time.Second}, "query options must not be negative"},
+		{CmdGetValue, QueryOpts{Output: "base32"}, "unknown encoding"},
+	}
+	for _, c := range cases {
+		err := c.opts.Check(c.cmd)
+		switch {
+		case c.err == "" && err != nil:
+			t.Errorf("%s %v: %v", c.cmd, c.opts, err)
+		case c.err != "" && err == nil:
+			t.Errorf("%s %v: want error %q", c.cmd, c.opts, c.err)
+		case c.err != "" && !strings.HasPrefix(err.Error(), c.err):
+			t.Errorf("%s %v: got error %q, want %q", c.cmd, c.opts, err, c.err)
+		}
+	}
+}
@@ -2,40 +2,144 @@ package dhttracer
 
 import (
 	"context"
+	"embed"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html"
 	"io"
+	"io/fs"
+	"mime"
 	"net/http"
 	"os"
 	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 
 	lwriter "github.com/ipfs/go-log/writer"
 	dhtnode "github.com/libp2p/dht-tracer1/lib/dhtnode"
+	dhtquery "github.com/libp2p/dht-tracer1/lib/dhtquery"
+	tabular "github.com/libp2p/dht-tracer1/lib/tabular"
+	workload "github.com/libp2p/dht-tracer1/lib/workload"
 )
 
 type HTTPServer struct {
 	Tracer *Tracer
 	Mux    *http.ServeMux
 	Server http.Server
+
+	// Token, if set, is required of every request. See ServeHTTP.
+	Token string
+	// ReadOnly refuses the MutatingCmds, and workloads.
+	ReadOnly bool
+
+	metrics http.Handler
+
+	streams      context.Context // canceled on shutdown, to end streams
+	closeStreams context.CancelFunc
+	shutdown     sync.Once
+	done         chan struct{} // closed once shut down
+	shutdownErr  error
 }
 
+// ShutdownTimeout is how long a shutdown waits for running requests and
+// queries, before canceling them.
+var ShutdownTimeout = 30 * time.Second
+
 func NewHTTPServer(t *Tracer, addr string) *HTTPServer {
-	s := &HTTPServer{Tracer: t}
+	s := &HTTPServer{Tracer: t, done: make(chan struct{})}
+	s.streams, s.closeStreams = context.WithCancel(context.Background())
 
 	s.Mux = http.NewServeMux()
+	s.Mux.Handle("/", uiHandler())
 	s.Mux.HandleFunc("/cmd", s.handleCmd)
+	s.Mux.HandleFunc(APIPrefix, s.handleAPI)
 	s.Mux.HandleFunc("/events", s.handleEvents)
+	s.Mux.HandleFunc("/eventlog", s.handleEventlog)
+	s.Mux.HandleFunc("/vis/ws", s.handleVisWS)
+	s.Mux.HandleFunc("/workload", s.handleWorkload)
+	s.Mux.HandleFunc("/loadtest", s.handleLoadTest)
+	s.Mux.HandleFunc("/traces", s.handleTraces)
+	s.Mux.HandleFunc("/trace", s.handleTrace)
+	s.Mux.HandleFunc("/export", s.handleExport)
+	s.Mux.HandleFunc("/metrics", s.handleMetrics)
 	s.Mux.HandleFunc("/version", s.handleVersion)
 	s.Mux.HandleFunc("/info/switch", s.handleInfoSwitch)
+	s.Mux.HandleFunc("/info/routing-table", s.handleInfoRoutingTable)
+
+	qm := NewQueryMetrics()
+	t.AddSink(qm)
+	h, err := MetricsHandler(t.Nodes, qm)
+	if err != nil {
+		h = http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			http.Error(res, fmt.Sprint(err), http.StatusInternalServerError)
+		})
+	}
+	s.metrics = h
 
 	s.Server.Addr = addr
-	s.Server.Handler = s.Mux
+	s.Server.Handler = s
+	s.Server.RegisterOnShutdown(s.closeStreams)
 	return s
 }
 
+//go:embed ui
+var uiFiles embed.FS
+
+// uiHandler serves the web ui. It is mounted at /, so it also answers
+// unknown paths, with a 404.
+func uiHandler() http.Handler {
+	files, _ := fs.Sub(uiFiles, "ui")
+	return http.FileServer(http.FS(files))
+}
+
+// ListenAndServe serves http until the server is shut down, and returns
+// once the shutdown finished.
 func (s *HTTPServer) ListenAndServe() error {
-	return s.Server.ListenAndServe()
+	return s.served(s.Server.ListenAndServe())
+}
+
+// ListenAndServeTLS serves https, with the cert and key in PEM files.
+func (s *HTTPServer) ListenAndServeTLS(certFile, keyFile string) error {
+	return s.served(s.Server.ListenAndServeTLS(certFile, keyFile))
+}
+
+func (s *HTTPServer) served(err error) error {
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	<-s.done
+	return s.shutdownErr
+}
+
+// Shutdown stops the server gracefully: it stops listening, ends event
+// streams, and waits for running requests until ctx is done. Then it stops
+// the tracer, and prints what was stopped. Calling it again waits for the
+// first shutdown to finish.
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	s.shutdown.Do(func() {
+		defer close(s.done)
+		fmt.Println("shutting down...")
+
+		var errs []string
+		if err := s.Server.Shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Sprintf("http server: %v", err))
+		} else {
+			fmt.Println("closed http server")
+		}
+
+		r, err := s.Tracer.Stop(ctx)
+		io.Copy(os.Stdout, r)
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+		if len(errs) > 0 {
+			s.shutdownErr = errors.New(strings.Join(errs, "; "))
+		}
+	})
+	<-s.done
+	return s.shutdownErr
 }
 
 func (s *HTTPServer) handleVersion(res http.ResponseWriter, req *http.Request) {
@@ -43,6 +147,11 @@ func (s *HTTPServer) handleVersion(res http.ResponseWriter, req *http.Request) {
 	fmt.Fprintf(res, "tracedht version %v\n", Version)
 }
 
+// handleMetrics does not log: prometheus scrapes it every few seconds.
+func (s *HTTPServer) handleMetrics(res http.ResponseWriter, req *http.Request) {
+	s.metrics.ServeHTTP(res, req)
+}
+
 func (s *HTTPServer) handleInfoSwitch(res http.ResponseWriter, req *http.Request) {
 	fmt.Fprintln(os.Stderr, "/switch")
 
@@ -53,8 +162,18 @@ func (s *HTTPServer) handleInfoSwitch(res http.ResponseWriter, req *http.Request
 	dhtnode.PrintLatencyTable(res, s.Tracer.Node.Host)
 }
 
+func (s *HTTPServer) handleInfoRoutingTable(res http.ResponseWriter, req *http.Request) {
+	fmt.Fprintln(os.Stderr, "/routing-table")
+
+	s.Tracer.RLock()
+	defer s.Tracer.RUnlock()
+	dhtnode.PrintRoutingTable(res, s.Tracer.Node)
+}
+
+// handleCmd runs ?q=<command>. The POST body of put-value, unless it is a
+// form, is its value, as is, for binary values.
 func (s *HTTPServer) handleCmd(res http.ResponseWriter, req *http.Request) {
-	// parse form
+	// parse form. it leaves bodies that are not forms alone
 	if err := req.ParseForm(); err != nil {
 		http.Error(res, fmt.Sprint(err), http.StatusBadRequest)
 		return
@@ -69,16 +188,59 @@ func (s *HTTPServer) handleCmd(res http.ResponseWriter, req *http.Request) {
 	}
 
 	fmt.Fprintf(os.Stderr, "/cmd %v %v\n", cmd, args)
+	if err := s.checkCmd(cmd); err != nil {
+		http.Error(res, fmt.Sprint(err), http.StatusForbidden)
+		return
+	}
+
+	var body []byte
+	if cmd == CmdPutValue && req.Method == http.MethodPost && !isForm(req) {
+		body, err = io.ReadAll(io.LimitReader(req.Body, int64(MaxValueSize)+1))
+		if err != nil {
+			http.Error(res, fmt.Sprint(err), http.StatusBadRequest)
+			return
+		}
+	}
+
 	// dispatch command
 	var r io.Reader
 	switch {
 	case cmd == CmdExit:
-		go s.Server.Close()
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+			defer cancel()
+			s.Shutdown(ctx)
+		}()
 		r = strings.NewReader("exiting...")
 	case cmd == CmdReset:
 		r, err = s.Tracer.Reset()
 	case cmdInGroup(cmd, QueryCmds):
-		r, err = s.Tracer.RunQuery(cmd, args[0], args[1:]...)
+		var q Query
+		q, err = NewQuery(cmd, args)
+		if err != nil {
+			http.Error(res, fmt.Sprint(err), http.StatusBadRequest)
+			return
+		}
+		if len(body) > 0 {
+			if err := q.SetValue(body); err != nil {
+				http.Error(res, fmt.Sprint(err), http.StatusBadRequest)
+				return
+			}
+		}
+		if cmd == CmdSearchValue {
+			s.streamSearchValue(res, req, q)
+			return
+		}
+
+		var qr *Result
+		qr, err = s.Tracer.Run(req.Context(), q)
+		if qr != nil {
+			res.Header().Set("X-Trace-Id", qr.Trace.ID)
+			if v, ok := qr.Value.([]byte); ok {
+				res.Header().Set("X-Record-Size", fmt.Sprint(len(v)))
+			}
+			r = qr.Out
+		}
 	}
 	if err != nil {
 		errs := fmt.Sprintf("error: %v", err)
@@ -90,10 +252,290 @@ func (s *HTTPServer) handleCmd(res http.ResponseWriter, req *http.Request) {
 	io.Copy(res, r)
 }
 
+// isForm tells if req has a form body.
+func isForm(req *http.Request) bool {
+	ct, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	return ct == "application/x-www-form-urlencoded" || ct == "multipart/form-data"
+}
+
+// streamSearchValue writes the records of a search-value query as they are
+// found. The trace id comes in a trailer, once the query finished.
+func (s *HTTPServer) streamSearchValue(res http.ResponseWriter, req *http.Request, q Query) {
+	flusher, _ := res.(http.Flusher)
+	res.Header().Set("Trailer", "X-Trace-Id")
+	res.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	res.WriteHeader(http.StatusOK)
+
+	q.OnRecord = func(r SearchRecord) {
+		fmt.Fprintln(res, r.Text(q.Opts.Output))
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+	qr, err := s.Tracer.Run(req.Context(), q)
+	if qr != nil {
+		res.Header().Set("X-Trace-Id", qr.Trace.ID)
+	}
+	if err != nil {
+		fmt.Fprintf(res, "error: %v\n", err)
+	}
+}
+
+func (s *HTTPServer) handleWorkload(res http.ResponseWriter, req *http.Request) {
+	if s.ReadOnly { // workloads put values and provider records
+		http.Error(res, "workloads are disabled: the server is read-only", http.StatusForbidden)
+		return
+	}
+	if err := req.ParseForm(); err != nil {
+		http.Error(res, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+
+	cfg := workload.DefaultCfg()
+	for k := range req.Form {
+		if err := cfg.Set(k, req.Form.Get(k)); err != nil {
+			http.Error(res, fmt.Sprint(err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "/workload %+v\n", cfg)
+	r, err := s.Tracer.RunWorkload(req.Context(), cfg)
+	if err != nil {
+		errs := fmt.Sprintf("error: %v", err)
+		http.Error(res, errs, http.StatusInternalServerError)
+		return
+	}
+	io.Copy(res, r)
+}
+
+func (s *HTTPServer) handleLoadTest(res http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(res, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+
+	cfg := DefaultLoadCfg()
+	for k, vs := range req.Form {
+		for _, v := range vs {
+			if err := cfg.Set(k, v); err != nil {
+				http.Error(res, fmt.Sprint(err), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "/loadtest %d queries\n", len(cfg.Queries))
+	for _, q := range cfg.Queries {
+		if err := s.checkCmd(q.Cmd); err != nil {
+			http.Error(res, fmt.Sprint(err), http.StatusForbidden)
+			return
+		}
+	}
+	r, err := s.Tracer.LoadTest(req.Context(), cfg)
+	if err != nil {
+		http.Error(res, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+	PrintLoadResult(res, r)
+}
+
+// handleTraces lists the recent query traces, latest last.
+func (s *HTTPServer) handleTraces(res http.ResponseWriter, req *http.Request) {
+	fmt.Fprintln(os.Stderr, "/traces")
+
+	tw := tabwriter.NewWriter(res, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "id\tquery\tduration\tpeers\terr")
+	for _, t := range s.Tracer.History.All() {
+		fmt.Fprintf(tw, "%s\t%s %s\t%v\t%d\t%s\n", t.ID, t.Cmd, t.Key,
+			t.Duration().Round(time.Millisecond), len(t.Peers), t.Err)
+	}
+	tw.Flush()
+}
+
+// handleTrace writes the trace with ?id=<id>, or the latest one, as json, or
+// with ?format=chrome as Chrome trace events, or with ?format=dot|svg as a
+// lookup graph.
+func (s *HTTPServer) handleTrace(res http.ResponseWriter, req *http.Request) {
+	id := req.FormValue("id")
+	format := req.FormValue("format")
+	fmt.Fprintf(os.Stderr, "/trace %v %v\n", id, format)
+
+	t := s.Tracer.History.Last()
+	if id != "" {
+		t = s.Tracer.History.Get(id)
+	}
+	if t == nil {
+		http.Error(res, "no such trace", http.StatusNotFound)
+		return
+	}
+
+	switch format {
+	case "", "json":
+		res.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(res).Encode(t)
+	case "chrome":
+		res.Header().Set("Content-Type", "application/json")
+		dhtquery.WriteChromeTrace(res, t)
+	case "dot":
+		res.Header().Set("Content-Type", "text/vnd.graphviz")
+		dhtquery.WriteDOT(res, t)
+	case "svg":
+		res.Header().Set("Content-Type", "image/svg+xml")
+		dhtquery.WriteSVG(res, t)
+	default:
+		http.Error(res, fmt.Sprintf("unknown format: %v", format), http.StatusBadRequest)
+	}
+}
+
+// handleExport writes recent traces as a table, see package tabular:
+// ?table=queries|peers&format=csv|columnar, filtered by id, cmd, since and
+// until.
+func (s *HTTPServer) handleExport(res http.ResponseWriter, req *http.Request) {
+	if err := req.ParseForm(); err != nil {
+		http.Error(res, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+
+	table, format := tabular.TablePeers, tabular.FormatCSV
+	var f tabular.Filter
+	for k := range req.Form {
+		v := req.Form.Get(k)
+		var err error
+		switch k {
+		case "table":
+			table = v
+		case "format":
+			format = v
+		default:
+			err = f.Set(k, v)
+		}
+		if err != nil {
+			http.Error(res, fmt.Sprint(err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "/export %s %s %+v\n", table, format, f)
+	t, err := tabular.NewTable(table, tabular.Select(s.Tracer.History.All(), f))
+	if err != nil {
+		http.Error(res, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+	switch format {
+	case tabular.FormatCSV:
+		res.Header().Set("Content-Type", "text/csv")
+	case tabular.FormatColumnar:
+		res.Header().Set("Content-Type", "application/json")
+	default:
+		http.Error(res, fmt.Sprintf("unknown format: %v", format), http.StatusBadRequest)
+		return
+	}
+	t.Write(res, format)
+}
+
+// handleEvents streams tracer events, as newline delimited json, or with
+// ?format=sse (or an Accept: text/event-stream header) as server-sent
+// events. Events are filtered by ?query=<id>, peer, type and subsystem. A
+// heartbeat is sent every ?heartbeat=<duration> (default: 15s, 0 for none).
 func (s *HTTPServer) handleEvents(res http.ResponseWriter, req *http.Request) {
-	fmt.Fprintln(os.Stderr, "/events")
+	if err := req.ParseForm(); err != nil {
+		http.Error(res, fmt.Sprint(err), http.StatusBadRequest)
+		return
+	}
+
+	sse := strings.Contains(req.Header.Get("Accept"), "text/event-stream")
+	heartbeat := 15 * time.Second
+	var f EventFilter
+	for k, vs := range req.Form {
+		for _, v := range vs {
+			var err error
+			switch k {
+			case "format":
+				sse = v == "sse"
+			case "heartbeat":
+				heartbeat, err = time.ParseDuration(v)
+			default:
+				err = f.Set(k, v)
+			}
+			if err != nil {
+				http.Error(res, fmt.Sprint(err), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	fmt.Fprintf(os.Stderr, "/events %+v\n", f)
+
+	flusher, _ := res.(http.Flusher)
+	if sse {
+		res.Header().Set("Content-Type", "text/event-stream")
+		res.Header().Set("Cache-Control", "no-cache")
+	} else {
+		res.Header().Set("Content-Type", "application/x-ndjson")
+	}
+	res.WriteHeader(http.StatusOK)
+	if flusher != nil {
+		flusher.Flush()
+	}
+
+	sub := s.Tracer.Events.Subscribe(f, 256)
+	defer s.Tracer.Events.Unsubscribe(sub)
+
+	var tick <-chan time.Time
+	if heartbeat > 0 {
+		t := time.NewTicker(heartbeat)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	write := func(e Event) error {
+		buf, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if sse {
+			_, err = fmt.Fprintf(res, "event: %s\ndata: %s\n\n", e.Type, buf)
+		} else {
+			_, err = fmt.Fprintf(res, "%s\n", buf)
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return err
+	}
+
+	for {
+		var err error
+		select {
+		case e := <-sub.C:
+			err = write(e)
+		case now := <-tick:
+			err = write(Event{
+				Time: now,
+				Type: EventHeartbeat,
+				Data: map[string]interface{}{"dropped": sub.Dropped()},
+			})
+		case <-req.Context().Done():
+			return
+		case <-s.streams.Done():
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handleEventlog streams the raw go-log eventlog of every subsystem.
+func (s *HTTPServer) handleEventlog(res http.ResponseWriter, req *http.Request) {
+	fmt.Fprintln(os.Stderr, "/eventlog")
+
+	ctx, cancel := context.WithCancel(req.Context())
+	defer cancel()
+	stop := context.AfterFunc(s.streams, cancel)
+	defer stop()
 
-	r := eventlogReader(req.Context())
+	r := eventlogReader(ctx)
 	io.Copy(res, r)
 }
 
@@ -106,7 +548,7 @@ func parseCmd(line string) (cmd string, args []string, err error) {
 	}
 
 	args = strings.Split(line, " ")
-	if len(args) < 2 {
+	if len(args) < 2 && !cmdInGroup(args[0], CtrlCmds) {
 		return "", nil, errors.New("command format: <command> <arg>... ")
 	}
 
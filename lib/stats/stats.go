@@ -0,0 +1,96 @@
+// Package stats summarizes distributions of measurements, such as query
+// latencies or hop counts.
+package stats
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Dist accumulates samples of a distribution.
+type Dist struct {
+	samples []float64
+	sorted  bool
+}
+
+func (d *Dist) Add(v float64) {
+	d.samples = append(d.samples, v)
+	d.sorted = false
+}
+
+func (d *Dist) AddDuration(v time.Duration) {
+	d.Add(float64(v))
+}
+
+func (d *Dist) Len() int {
+	return len(d.samples)
+}
+
+// Percentile returns the p-th (0-100) percentile, using nearest rank.
+func (d *Dist) Percentile(p float64) float64 {
+	if len(d.samples) == 0 {
+		return 0
+	}
+	if !d.sorted {
+		sort.Float64s(d.samples)
+		d.sorted = true
+	}
+
+	rank := int(math.Ceil(p / 100 * float64(len(d.samples))))
+	if rank < 1 {
+		rank = 1
+	}
+	return d.samples[rank-1]
+}
+
+func (d *Dist) Mean() float64 {
+	if len(d.samples) == 0 {
+		return 0
+	}
+	sum := 0.0
+	for _, v := range d.samples {
+		sum += v
+	}
+	return sum / float64(len(d.samples))
+}
+
+func (d *Dist) Summary() Summary {
+	return Summary{
+		N:    d.Len(),
+		Min:  d.Percentile(0),
+		Mean: d.Mean(),
+		P50:  d.Percentile(50),
+		P90:  d.Percentile(90),
+		P95:  d.Percentile(95),
+		P99:  d.Percentile(99),
+		Max:  d.Percentile(100),
+	}
+}
+
+// Summary is a point-in-time digest of a Dist.
+type Summary struct {
+	N    int
+	Min  float64
+	Mean float64
+	P50  float64
+	P90  float64
+	P95  float64
+	P99  float64
+	Max  float64
+}
+
+func (s Summary) String() string {
+	return fmt.Sprintf("n=%d min=%.3g p50=%.3g p90=%.3g p95=%.3g p99=%.3g max=%.3g mean=%.3g",
+		s.N, s.Min, s.P50, s.P90, s.P95, s.P99, s.Max, s.Mean)
+}
+
+// DurationString formats a summary of a Dist filled with AddDuration.
+func (s Summary) DurationString() string {
+	d := func(v float64) time.Duration {
+		return time.Duration(v).Round(time.Microsecond)
+	}
+	return fmt.Sprintf("n=%d min=%v p50=%v p90=%v p95=%v p99=%v max=%v mean=%v",
+		s.N, d(s.Min), d(s.P50), d(s.P90), d(s.P95), d(s.P99), d(s.Max), d(s.Mean))
+}
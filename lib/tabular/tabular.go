@@ -0,0 +1,378 @@
+// Package tabular flattens query traces into tables, for analysis in
+// notebooks: a row per query, or a row per (query, peer), written as csv or
+// as columnar json.
+package tabular
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	datafmts "github.com/libp2p/dht-tracer1/lib/datafmts/vis"
+	dhtquery "github.com/libp2p/dht-tracer1/lib/dhtquery"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+const (
+	TableQueries = "queries"
+	TablePeers   = "peers"
+)
+
+const (
+	FormatCSV      = "csv"
+	FormatColumnar = "columnar"
+)
+
+// Filter selects traces. Zero fields match everything.
+type Filter struct {
+	ID    string
+	Cmd   string
+	Since time.Time // queries started at or after
+	Until time.Time // queries started before
+}
+
+// Set sets a filter field by name: id, cmd, since or until. Times are
+// RFC3339, or durations before now, e.g. since=10m.
+func (f *Filter) Set(key, val string) error {
+	switch key {
+	case "id":
+		f.ID = val
+	case "cmd":
+		f.Cmd = val
+	case "since", "until":
+		t, err := parseTime(val)
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		if key == "since" {
+			f.Since = t
+		} else {
+			f.Until = t
+		}
+	default:
+		return fmt.Errorf("unknown filter: %v", key)
+	}
+	return nil
+}
+
+func parseTime(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+func (f Filter) Match(t *dhtquery.Trace) bool {
+	switch {
+	case f.ID != "" && t.ID != f.ID:
+		return false
+	case f.Cmd != "" && t.Cmd != f.Cmd:
+		return false
+	case !f.Since.IsZero() && t.Start.Before(f.Since):
+		return false
+	case !f.Until.IsZero() && !t.Start.Before(f.Until):
+		return false
+	}
+	return true
+}
+
+func Select(ts []*dhtquery.Trace, f Filter) []*dhtquery.Trace {
+	var sel []*dhtquery.Trace
+	for _, t := range ts {
+		if f.Match(t) {
+			sel = append(sel, t)
+		}
+	}
+	return sel
+}
+
+// QueryRow summarizes a trace in the vis data format. Queries that are
+// still running have no EndTime nor Result.
+func QueryRow(t *dhtquery.Trace) datafmts.QueryRow {
+	seen := map[peer.ID]bool{}
+	queried, dialed, closer, final := 0, 0, 0, 0
+	for _, p := range t.Peers {
+		seen[p.Peer] = true
+		for _, c := range p.CloserPeers {
+			seen[c] = true
+		}
+		closer += len(p.CloserPeers)
+		if p.Responded {
+			final++
+		}
+		if hasSpan(p, dhtquery.SpanRequest) {
+			queried++
+		}
+		if hasSpan(p, dhtquery.SpanDial) {
+			dialed++
+		}
+	}
+	delete(seen, t.Self)
+
+	res := datafmts.QueryResult{
+		Success:     t.Err == "",
+		CloserPeers: closer,
+		FinalSet:    final,
+		QueriedSet:  queried,
+	}
+	if res.Success && t.Cmd == "find-peer" {
+		res.FoundPeer = t.Key
+	}
+
+	r := datafmts.QueryRow{
+		QueryID: t.ID,
+		Key:     t.Key,
+		Type:    t.Cmd,
+		RunnerState: datafmts.QueryRunnerState{
+			PeersSeen:      len(seen),
+			PeersQueried:   queried,
+			PeersDialed:    dialed,
+			PeersRemaining: len(seen) - queried,
+			Result:         res,
+			StartTime:      timestamp(t.Start),
+			CurrTime:       timestamp(t.End),
+			EndTime:        timestamp(t.End),
+		},
+	}
+	if t.End.IsZero() { // still running
+		r.RunnerState.Result = datafmts.QueryResult{}
+		r.RunnerState.CurrTime = timestamp(time.Now())
+		r.RunnerState.EndTime = ""
+	}
+	return r
+}
+
+// PeerRows lists what a trace did with each peer, in the vis data format.
+// Spans still open have no End, and last till now.
+func PeerRows(t *dhtquery.Trace) []datafmts.QueryPeerRow {
+	var rows []datafmts.QueryPeerRow
+	for _, p := range t.Peers {
+		r := datafmts.QueryPeerRow{
+			QueryID:         t.ID,
+			QueryOrder:      p.Order,
+			PeerID:          p.Peer.String(),
+			XORDistance:     p.XORDistance,
+			Hops:            p.Hops,
+			CloserPeersRecv: len(p.CloserPeers),
+			CloserPeersNew:  p.CloserPeersNew,
+		}
+		var first, last time.Time
+		for _, s := range p.Spans {
+			span := datafmts.Span{
+				Type:     s.Type,
+				Start:    timestamp(s.Start),
+				End:      timestamp(s.End),
+				Duration: s.Duration().String(),
+			}
+			if s.End.IsZero() { // still open
+				s.End = time.Now()
+				span.End = ""
+				span.Duration = s.Duration().String()
+			}
+			r.Spans = append(r.Spans, span)
+			if first.IsZero() || s.Start.Before(first) {
+				first = s.Start
+			}
+			if s.End.After(last) {
+				last = s.End
+			}
+		}
+		r.TotalDuration = last.Sub(first).String()
+		rows = append(rows, r)
+	}
+	return rows
+}
+
+func hasSpan(p *dhtquery.PeerTrace, typ string) bool {
+	for _, s := range p.Spans {
+		if s.Type == typ {
+			return true
+		}
+	}
+	return false
+}
+
+func timestamp(t time.Time) string {
+	return t.Format(time.RFC3339Nano)
+}
+
+// Column types in columnar output.
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeFloat  = "float"
+	TypeBool   = "bool"
+)
+
+type Column struct {
+	Name   string        `json:"name"`
+	Type   string        `json:"type"`
+	Values []interface{} `json:"values"`
+}
+
+// Table is a set of equal length columns.
+type Table struct {
+	Name    string    `json:"table"`
+	Rows    int       `json:"rows"`
+	Columns []*Column `json:"columns"`
+}
+
+func newTable(name string, cols ...[2]string) *Table {
+	t := &Table{Name: name}
+	for _, c := range cols {
+		t.Columns = append(t.Columns, &Column{Name: c[0], Type: c[1], Values: []interface{}{}})
+	}
+	return t
+}
+
+func (t *Table) addRow(vals ...interface{}) {
+	for i, v := range vals {
+		t.Columns[i].Values = append(t.Columns[i].Values, v)
+	}
+	t.Rows++
+}
+
+// QueryTable has a row per trace.
+func QueryTable(ts []*dhtquery.Trace) *Table {
+	tbl := newTable(TableQueries,
+		[2]string{"query_id", TypeString},
+		[2]string{"command", TypeString},
+		[2]string{"key", TypeString},
+		[2]string{"start", TypeString},
+		[2]string{"end", TypeString},
+		[2]string{"duration_ms", TypeFloat},
+		[2]string{"success", TypeBool},
+		[2]string{"err", TypeString},
+		[2]string{"messages", TypeInt},
+		[2]string{"max_hops", TypeInt},
+		[2]string{"peers_seen", TypeInt},
+		[2]string{"peers_dialed", TypeInt},
+		[2]string{"peers_queried", TypeInt},
+		[2]string{"peers_responded", TypeInt},
+		[2]string{"closer_peers", TypeInt},
+	)
+	for _, t := range ts {
+		r := QueryRow(t)
+		s := r.RunnerState
+		tbl.addRow(r.QueryID, r.Type, r.Key, s.StartTime, s.EndTime,
+			ms(t.Duration()), s.Result.Success, t.Err, t.Messages, t.MaxHops(),
+			s.PeersSeen, s.PeersDialed, s.PeersQueried, s.Result.FinalSet,
+			s.Result.CloserPeers)
+	}
+	return tbl
+}
+
+// PeerTable has a row per (trace, peer), with the durations of spans
+// summed up by type.
+func PeerTable(ts []*dhtquery.Trace) *Table {
+	tbl := newTable(TablePeers,
+		[2]string{"query_id", TypeString},
+		[2]string{"command", TypeString},
+		[2]string{"key", TypeString},
+		[2]string{"query_order", TypeInt},
+		[2]string{"peer_id", TypeString},
+		[2]string{"xor_distance", TypeInt},
+		[2]string{"hops", TypeInt},
+		[2]string{"responded", TypeBool},
+		[2]string{"err", TypeString},
+		[2]string{"start_ms", TypeFloat}, // since the query started
+		[2]string{"total_ms", TypeFloat},
+		[2]string{"dial_ms", TypeFloat},
+		[2]string{"request_ms", TypeFloat},
+		[2]string{"put_ms", TypeFloat},
+		[2]string{"spans", TypeInt},
+		[2]string{"closer_peers_recv", TypeInt},
+		[2]string{"closer_peers_new", TypeInt},
+	)
+	for _, t := range ts {
+		for i, r := range PeerRows(t) {
+			p := t.Peers[i]
+			byType := map[string]time.Duration{}
+			for _, s := range r.Spans {
+				d, _ := time.ParseDuration(s.Duration)
+				byType[s.Type] += d
+			}
+			var start time.Duration
+			if len(p.Spans) > 0 {
+				start = p.Spans[0].Start.Sub(t.Start)
+			}
+			total, _ := time.ParseDuration(r.TotalDuration)
+
+			tbl.addRow(r.QueryID, t.Cmd, t.Key, r.QueryOrder, r.PeerID,
+				r.XORDistance, r.Hops, p.Responded, p.Err, ms(start), ms(total),
+				ms(byType[dhtquery.SpanDial]), ms(byType[dhtquery.SpanRequest]),
+				ms(byType[dhtquery.SpanPut]), len(r.Spans),
+				r.CloserPeersRecv, r.CloserPeersNew)
+		}
+	}
+	return tbl
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// NewTable builds the named table from ts.
+func NewTable(name string, ts []*dhtquery.Trace) (*Table, error) {
+	switch name {
+	case TableQueries:
+		return QueryTable(ts), nil
+	case TablePeers:
+		return PeerTable(ts), nil
+	default:
+		return nil, fmt.Errorf("unknown table: %v", name)
+	}
+}
+
+// Write writes the table in format.
+func (t *Table) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatCSV:
+		return t.WriteCSV(w)
+	case FormatColumnar:
+		return t.WriteColumnar(w)
+	default:
+		return fmt.Errorf("unknown format: %v", format)
+	}
+}
+
+// WriteCSV writes the table as csv, with a header row.
+func (t *Table) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	header := make([]string, len(t.Columns))
+	for i, c := range t.Columns {
+		header[i] = c.Name
+	}
+	cw.Write(header)
+
+	rec := make([]string, len(t.Columns))
+	for row := 0; row < t.Rows; row++ {
+		for i, c := range t.Columns {
+			rec[i] = csvValue(c.Values[row])
+		}
+		cw.Write(rec)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func csvValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// WriteColumnar writes the table as json, column by column, the way
+// columnar formats like parquet lay out data. pandas reads it with
+// pd.DataFrame({c["name"]: c["values"] for c in t["columns"]}).
+func (t *Table) WriteColumnar(w io.Writer) error {
+	return json.NewEncoder(w).Encode(t)
+}
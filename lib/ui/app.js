@@ -0,0 +1,210 @@
+// tracedht web ui: issues commands, and renders the live query feed from
+// /vis/ws in the vis-state layout: a section per query, with a row per
+// peer, and the peer's spans as bars on the query's timeline.
+'use strict';
+
+const sections = new Map(); // query id -> {query, peers: Map(peer id -> row), el}
+const table = document.getElementById('query-table');
+const statusEl = document.getElementById('status');
+
+function ts(s) {
+  return s ? Date.parse(s) : Date.now();
+}
+
+function el(tag, attrs, ...children) {
+  const e = document.createElement(tag);
+  Object.assign(e, attrs || {});
+  for (const c of children) {
+    e.append(c);
+  }
+  return e;
+}
+
+function shortID(id) {
+  return id.length > 12 ? '…' + id.slice(-10) : id;
+}
+
+// section returns the rendered section of a query, creating it on first use.
+// newer queries go on top.
+function section(id) {
+  let sec = sections.get(id);
+  if (sec) {
+    return sec;
+  }
+  sec = {query: null, peers: new Map(), el: el('tbody', {className: 'query'})};
+  sec.el.addEventListener('click', (e) => {
+    if (e.target.closest('tr.query-row') && !e.target.closest('a')) {
+      sec.el.classList.toggle('collapsed');
+    }
+  });
+  sections.set(id, sec);
+  table.insertBefore(sec.el, table.tBodies[0] || null);
+  return sec;
+}
+
+function setQuery(q) {
+  const sec = section(q.QueryID);
+  sec.query = q;
+  render(sec);
+}
+
+function setPeer(p) {
+  const sec = section(p.QueryID);
+  sec.peers.set(p.PeerID, p);
+  render(sec);
+}
+
+function setSnapshot(qs) {
+  for (const s of qs) {
+    const sec = section(s.Query.QueryID);
+    sec.query = s.Query;
+    for (const p of s.PeerQueries || []) {
+      sec.peers.set(p.PeerID, p);
+    }
+    if (s.Query.RunnerState.EndTime) {
+      sec.el.classList.add('collapsed'); // history
+    }
+    render(sec);
+  }
+}
+
+function render(sec) {
+  const q = sec.query;
+  if (!q) {
+    return;
+  }
+  const st = q.RunnerState;
+  const start = ts(st.StartTime);
+  const end = st.EndTime ? ts(st.EndTime) : ts(st.CurrTime);
+  const total = Math.max(end - start, 1);
+  const running = !st.EndTime;
+
+  sec.el.classList.toggle('running', running);
+  sec.el.classList.toggle('failed', !running && !st.Result.Success);
+
+  const result = running ? 'running' : (st.Result.Success ? 'ok' : 'failed');
+  const links = el('span', {className: 'links'},
+    el('a', {href: 'trace?format=svg&id=' + q.QueryID, target: '_blank', textContent: 'graph'}),
+    el('a', {href: 'trace?format=chrome&id=' + q.QueryID, download: q.QueryID + '.json', textContent: 'perfetto'}),
+    el('a', {href: 'trace?id=' + q.QueryID, target: '_blank', textContent: 'json'}));
+  const header = el('tr', {className: 'query-row'},
+    el('td', {colSpan: 5, textContent:
+      `${q.Type} ${q.Key}  ${result}  ${total} ms  ` +
+      `seen ${st.PeersSeen}  dialed ${st.PeersDialed}  queried ${st.PeersQueried}  ` +
+      `responded ${st.Result.FinalSet || 0}  ` + new Date(start).toLocaleTimeString()}),
+    el('td', {className: 'bars'}, links));
+
+  const rows = [...sec.peers.values()].sort((a, b) => a.QueryOrder - b.QueryOrder);
+  const children = [header];
+  for (const p of rows) {
+    const track = el('div', {className: 'track'});
+    let responded = false;
+    for (const s of p.Spans || []) {
+      const s0 = ts(s.Start);
+      const s1 = s.End ? ts(s.End) : end;
+      const bar = el('div', {
+        className: 'span ' + s.Type + (s.End ? '' : ' open'),
+        title: `${s.Type} ${s.Duration}`,
+      });
+      bar.style.left = (100 * (s0 - start) / total) + '%';
+      bar.style.width = (100 * (s1 - s0) / total) + '%';
+      track.append(bar);
+      if (s.Type === 'Request' && s.End) {
+        responded = true;
+      }
+    }
+    children.push(el('tr', {className: 'peer-row' + (responded || running ? '' : ' no-response')},
+      el('td', {textContent: p.QueryOrder}),
+      el('td', {className: 'peer', title: p.PeerID, textContent: shortID(p.PeerID)}),
+      el('td', {textContent: p.XORDistance}),
+      el('td', {textContent: p.Hops}),
+      el('td', {textContent: `${p.CloserPeersRecv} (${p.CloserPeersNew})`}),
+      el('td', {className: 'bars'}, track)));
+  }
+  sec.el.replaceChildren(...children);
+}
+
+function connect() {
+  const proto = location.protocol === 'https:' ? 'wss:' : 'ws:';
+  const ws = new WebSocket(`${proto}//${location.host}${location.pathname.replace(/[^/]*$/, '')}vis/ws`);
+  ws.onopen = () => {
+    statusEl.textContent = 'live';
+    statusEl.classList.add('live');
+  };
+  ws.onclose = () => {
+    statusEl.textContent = 'disconnected, retrying...';
+    statusEl.classList.remove('live');
+    setTimeout(connect, 2000);
+  };
+  ws.onmessage = (m) => {
+    const msg = JSON.parse(m.data);
+    switch (msg.type) {
+      case 'snapshot':
+        setSnapshot(msg.queries || []);
+        break;
+      case 'query':
+        setQuery(msg.query);
+        break;
+      case 'peer':
+        setPeer(msg.peer);
+        break;
+    }
+  };
+}
+
+// the request body field each command takes its key in.
+const keyFields = {
+  'put-value': 'key',
+  'get-value': 'key',
+  'add-provider': 'cid',
+  'get-providers': 'cid',
+  'find-peer': 'peer',
+  'ping': 'peer',
+  'get-closest-peers': 'key',
+  'search-value': 'key',
+  'ipns-publish': 'key',
+  'ipns-resolve': 'name',
+};
+
+async function runCommand(e) {
+  e.preventDefault();
+  const out = document.getElementById('cmd-out');
+  const cmd = document.getElementById('cmd-name').value;
+  const body = {[keyFields[cmd]]: document.getElementById('cmd-key').value.trim()};
+  if (cmd === 'put-value') {
+    body.value = document.getElementById('cmd-value').value;
+  } else if (cmd === 'ipns-publish') {
+    body.path = document.getElementById('cmd-value').value.trim();
+  }
+  out.classList.remove('error');
+  out.textContent = `running ${cmd}...`;
+
+  const res = await fetch('api/v1/' + cmd, {
+    method: 'POST',
+    headers: {'Content-Type': 'application/json'},
+    body: JSON.stringify(body),
+  });
+  out.classList.toggle('error', !res.ok);
+  if (!res.headers.get('Content-Type').startsWith('application/json')) {
+    out.textContent = await res.text(); // e.g. unauthorized
+    return;
+  }
+  const reply = await res.json();
+  out.textContent = res.ok ?
+    JSON.stringify(reply.result || 'ok', null, 2) + `\n${reply.duration_ms.toFixed(1)} ms` :
+    `${reply.error.code}: ${reply.error.message}`;
+}
+
+document.getElementById('cmd-form').addEventListener('submit', (e) => {
+  runCommand(e).catch((err) => {
+    const out = document.getElementById('cmd-out');
+    out.classList.add('error');
+    out.textContent = String(err);
+  });
+});
+
+fetch('version').then((r) => r.text()).then((v) => {
+  document.getElementById('version').textContent = v.trim();
+});
+
+connect();
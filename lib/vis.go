@@ -0,0 +1,169 @@
+package dhttracer
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	websocket "github.com/gorilla/websocket"
+	datafmts "github.com/libp2p/dht-tracer1/lib/datafmts/vis"
+	dhtquery "github.com/libp2p/dht-tracer1/lib/dhtquery"
+	tabular "github.com/libp2p/dht-tracer1/lib/tabular"
+)
+
+// Vis feed message types.
+const (
+	VisSnapshot = "snapshot" // all running and recent queries
+	VisQuery    = "query"    // a query's runner state changed
+	VisPeer     = "peer"     // a query's dealings with a peer changed
+)
+
+// VisUpdateInterval is how often the vis feed sends the changes of running
+// queries. Changes in between are coalesced.
+var VisUpdateInterval = 100 * time.Millisecond
+
+// VisMessage is a message on the vis feed, in the datafmts vis layout.
+type VisMessage struct {
+	Type    string                  `json:"type"`
+	Queries []datafmts.QuerySection `json:"queries,omitempty"` // snapshot
+	Query   *datafmts.QueryRow      `json:"query,omitempty"`
+	Peer    *datafmts.QueryPeerRow  `json:"peer,omitempty"`
+}
+
+// allPeers marks all of a query's peers as changed.
+const allPeers = "*"
+
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	// the feed is read only, so any page may show it.
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// VisSection returns the vis section of a trace, which may still be
+// running.
+func VisSection(t *dhtquery.Trace) datafmts.QuerySection {
+	t = t.Snapshot()
+	return datafmts.QuerySection{
+		Query:       tabular.QueryRow(t),
+		PeerQueries: tabular.PeerRows(t),
+	}
+}
+
+// handleVisWS is a websocket feed for the visualizer. On connect, it sends
+// a snapshot of the recent and running queries, then incremental updates of
+// queries and their peers as they change.
+func (s *HTTPServer) handleVisWS(res http.ResponseWriter, req *http.Request) {
+	fmt.Fprintln(os.Stderr, "/vis/ws")
+
+	conn, err := wsUpgrader.Upgrade(res, req, nil)
+	if err != nil {
+		return // Upgrade replied with an error
+	}
+	defer conn.Close()
+
+	// subscribe before the snapshot, so no change is missed.
+	sub := s.Tracer.Events.Subscribe(EventFilter{
+		Subsystems: []string{SubsystemQuery, SubsystemDHT},
+	}, 1024)
+	defer s.Tracer.Events.Unsubscribe(sub)
+
+	if err := conn.WriteJSON(s.visSnapshot()); err != nil {
+		return
+	}
+
+	// the client does not send anything, but reading handles pings and
+	// notices when it goes away.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	tick := time.NewTicker(VisUpdateInterval)
+	defer tick.Stop()
+
+	dirtyQueries := map[string]bool{}
+	dirtyPeers := map[string]map[string]bool{} // query id -> peers
+	dropped := 0
+	for {
+		select {
+		case e := <-sub.C:
+			if e.QueryID == "" {
+				continue
+			}
+			dirtyQueries[e.QueryID] = true
+			peer := e.Peer
+			if e.Type == EventQueryEnd {
+				peer = allPeers // finishing closes spans of any peer
+			}
+			if peer != "" {
+				if dirtyPeers[e.QueryID] == nil {
+					dirtyPeers[e.QueryID] = map[string]bool{}
+				}
+				dirtyPeers[e.QueryID][peer] = true
+			}
+		case <-tick.C:
+			var err error
+			if d := sub.Dropped(); d > dropped {
+				// changes were lost, so resync the client.
+				dropped = d
+				err = conn.WriteJSON(s.visSnapshot())
+			} else {
+				err = s.sendVisUpdates(conn, dirtyQueries, dirtyPeers)
+			}
+			if err != nil {
+				return
+			}
+			dirtyQueries = map[string]bool{}
+			dirtyPeers = map[string]map[string]bool{}
+		case <-closed:
+			return
+		case <-req.Context().Done():
+			return
+		case <-s.streams.Done():
+			return
+		}
+	}
+}
+
+// visSnapshot returns a snapshot of the recent and running queries.
+func (s *HTTPServer) visSnapshot() VisMessage {
+	snap := VisMessage{Type: VisSnapshot, Queries: []datafmts.QuerySection{}}
+	seen := map[string]bool{}
+	for _, t := range append(s.Tracer.History.All(), s.Tracer.Active()...) {
+		if !seen[t.ID] { // a query may just be moving into the history
+			seen[t.ID] = true
+			snap.Queries = append(snap.Queries, VisSection(t))
+		}
+	}
+	return snap
+}
+
+func (s *HTTPServer) sendVisUpdates(conn *websocket.Conn, queries map[string]bool, peers map[string]map[string]bool) error {
+	for id := range queries {
+		t := s.Tracer.FindTrace(id)
+		if t == nil {
+			continue
+		}
+		sec := VisSection(t)
+		if err := conn.WriteJSON(VisMessage{Type: VisQuery, Query: &sec.Query}); err != nil {
+			return err
+		}
+		for i := range sec.PeerQueries {
+			p := &sec.PeerQueries[i]
+			if !peers[id][p.PeerID] && !peers[id][allPeers] {
+				continue
+			}
+			if err := conn.WriteJSON(VisMessage{Type: VisPeer, Peer: p}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+// Package workload populates dht nodes with value and provider records,
+// and then reads them back at a target rate.
+package workload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	dhtnode "github.com/libp2p/dht-tracer1/lib/dhtnode"
+	stats "github.com/libp2p/dht-tracer1/lib/stats"
+	mh "github.com/multiformats/go-multihash"
+)
+
+const (
+	OpPutValue     = "put-value"
+	OpGetValue     = "get-value"
+	OpAddProvider  = "add-provider"
+	OpGetProviders = "get-providers"
+)
+
+const (
+	DistUniform = "uniform"
+	DistZipf    = "zipf"
+)
+
+// Cfg describes a workload.
+type Cfg struct {
+	Values    int    // value records to put
+	Providers int    // provider records to add
+	Dist      string // key popularity for reads: DistUniform or DistZipf
+	ZipfS     float64
+
+	Reads       int     // reads to issue after populating
+	Rate        float64 // reads per second. 0 means as fast as Concurrency allows
+	Concurrency int
+	Timeout     time.Duration // per operation
+}
+
+func DefaultCfg() Cfg {
+	return Cfg{
+		Values:      100,
+		Providers:   100,
+		Dist:        DistUniform,
+		ZipfS:       1.1,
+		Reads:       1000,
+		Concurrency: 10,
+		Timeout:     time.Minute,
+	}
+}
+
+// MaxRate is the highest rate a ticker can run at: one per nanosecond.
+const MaxRate = 1e9
+
+// CheckRate returns an error if rate, per second, is not between 0 (for
+// unlimited) and MaxRate.
+func CheckRate(rate float64) error {
+	if !(rate >= 0 && rate <= MaxRate) {
+		return fmt.Errorf("rate must be between 0 and %g, got %v", float64(MaxRate), rate)
+	}
+	return nil
+}
+
+func checkZipfS(s float64) error {
+	if !(s > 1) {
+		return fmt.Errorf("invalid zipf-s %v, must be > 1", s)
+	}
+	return nil
+}
+
+// Check returns an error if the workload cannot run as configured.
+func (c Cfg) Check() error {
+	if c.Dist == DistZipf {
+		if err := checkZipfS(c.ZipfS); err != nil {
+			return err
+		}
+	}
+	return CheckRate(c.Rate)
+}
+
+// Set sets a Cfg field by its option name: values, providers, reads, dist,
+// zipf-s, rate, concurrency or timeout.
+func (c *Cfg) Set(key, val string) error {
+	var err error
+	switch key {
+	case "values":
+		c.Values, err = strconv.Atoi(val)
+	case "providers":
+		c.Providers, err = strconv.Atoi(val)
+	case "reads":
+		c.Reads, err = strconv.Atoi(val)
+	case "dist":
+		if val != DistUniform && val != DistZipf {
+			return fmt.Errorf("unknown key distribution: %v", val)
+		}
+		c.Dist = val
+	case "zipf-s":
+		if c.ZipfS, err = strconv.ParseFloat(val, 64); err == nil {
+			err = checkZipfS(c.ZipfS)
+		}
+	case "rate":
+		if c.Rate, err = strconv.ParseFloat(val, 64); err == nil {
+			err = CheckRate(c.Rate)
+		}
+	case "concurrency":
+		c.Concurrency, err = strconv.Atoi(val)
+	case "timeout":
+		c.Timeout, err = time.ParseDuration(val)
+	default:
+		return fmt.Errorf("unknown workload option: %v", key)
+	}
+	return err
+}
+
+// ParseArgs builds a Cfg from DefaultCfg and key=value args.
+func ParseArgs(args []string) (Cfg, error) {
+	c := DefaultCfg()
+	for _, a := range args {
+		kv := strings.SplitN(a, "=", 2)
+		if len(kv) != 2 {
+			return c, fmt.Errorf("workload option format: <key>=<value>, got %v", a)
+		}
+		if err := c.Set(kv[0], kv[1]); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// OpStats are the results of one type of operation.
+type OpStats struct {
+	Op       string
+	Ops      int
+	Failures int
+	Latency  stats.Dist
+}
+
+func (s *OpStats) SuccessRate() float64 {
+	if s.Ops == 0 {
+		return 0
+	}
+	return float64(s.Ops-s.Failures) / float64(s.Ops)
+}
+
+// Workload tracks the records it put, and the results of its operations.
+type Workload struct {
+	Cfg       Cfg
+	Values    []string
+	Providers []cid.Cid
+
+	mu  sync.Mutex
+	ops map[string]*OpStats
+}
+
+func New(cfg Cfg) *Workload {
+	return &Workload{Cfg: cfg, ops: map[string]*OpStats{}}
+}
+
+// Run populates the nodes, and then reads from them.
+func (w *Workload) Run(ctx context.Context, nodes []*dhtnode.Node) error {
+	if err := w.Populate(ctx, nodes); err != nil {
+		return err
+	}
+	return w.Read(ctx, nodes)
+}
+
+// Populate puts Cfg.Values values and adds Cfg.Providers provider records,
+// each from a random node.
+func (w *Workload) Populate(ctx context.Context, nodes []*dhtnode.Node) error {
+	if len(nodes) < 1 {
+		return errors.New("workload needs at least one node")
+	}
+	// fail before writing records that could not be read back
+	if err := w.Cfg.Check(); err != nil {
+		return err
+	}
+
+	prefix := fmt.Sprintf("/v/workload-%x", rand.Uint32())
+	for i := 0; i < w.Cfg.Values; i++ {
+		w.Values = append(w.Values, fmt.Sprintf("%s-%d", prefix, i))
+	}
+	for i := 0; i < w.Cfg.Providers; i++ {
+		h, err := mh.Sum([]byte(fmt.Sprintf("%s-%d", prefix, i)), mh.SHA2_256, -1)
+		if err != nil {
+			return err
+		}
+		w.Providers = append(w.Providers, cid.NewCidV1(cid.Raw, h))
+	}
+
+	w.runOps(ctx, 0, len(w.Values)+len(w.Providers), func(i int) (string, func(context.Context) error) {
+		n := nodes[rand.Intn(len(nodes))]
+		if i < len(w.Values) {
+			k := w.Values[i]
+			return OpPutValue, func(ctx context.Context) error {
+				return n.DHT.PutValue(ctx, k, []byte(k))
+			}
+		}
+		c := w.Providers[i-len(w.Values)]
+		return OpAddProvider, func(ctx context.Context) error {
+			return n.DHT.Provide(ctx, c, true)
+		}
+	})
+	return ctx.Err()
+}
+
+// Read issues Cfg.Reads reads of the populated records, from random nodes,
+// with keys drawn from Cfg.Dist.
+func (w *Workload) Read(ctx context.Context, nodes []*dhtnode.Node) error {
+	items := len(w.Values) + len(w.Providers)
+	if items < 1 {
+		return errors.New("no records to read. populate first")
+	}
+
+	next := func() int { return rand.Intn(items) }
+	if w.Cfg.Dist == DistZipf && items > 1 {
+		// rank 0 is the most popular key. shuffle so popularity does not
+		// follow record type.
+		perm := rand.Perm(items)
+		z := rand.NewZipf(rand.New(rand.NewSource(time.Now().UnixNano())), w.Cfg.ZipfS, 1, uint64(items-1))
+		if z == nil {
+			return fmt.Errorf("invalid zipf-s %v, must be > 1", w.Cfg.ZipfS)
+		}
+		next = func() int { return perm[z.Uint64()] }
+	}
+
+	w.runOps(ctx, w.Cfg.Rate, w.Cfg.Reads, func(int) (string, func(context.Context) error) {
+		n := nodes[rand.Intn(len(nodes))]
+		i := next()
+		if i < len(w.Values) {
+			k := w.Values[i]
+			return OpGetValue, func(ctx context.Context) error {
+				_, err := n.DHT.GetValue(ctx, k)
+				return err
+			}
+		}
+		c := w.Providers[i-len(w.Values)]
+		return OpGetProviders, func(ctx context.Context) error {
+			if _, ok := <-n.DHT.FindProvidersAsync(ctx, c, 1); !ok {
+				return errors.New("no providers found")
+			}
+			return nil
+		}
+	})
+	return ctx.Err()
+}
+
+// runOps runs count ops with up to Cfg.Concurrency in flight, starting at
+// most rate per second (0 for no limit). mkOp is called sequentially.
+func (w *Workload) runOps(ctx context.Context, rate float64, count int, mkOp func(i int) (string, func(context.Context) error)) {
+	conc := w.Cfg.Concurrency
+	if conc < 1 {
+		conc = 1
+	}
+	sem := make(chan struct{}, conc)
+
+	var tick <-chan time.Time
+	if rate > 0 {
+		t := time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer t.Stop()
+		tick = t.C
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < count && ctx.Err() == nil; i++ {
+		if tick != nil {
+			select {
+			case <-tick:
+			case <-ctx.Done():
+				continue
+			}
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			continue
+		}
+
+		op, fn := mkOp(i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			octx, cancel := context.WithTimeout(ctx, w.Cfg.Timeout)
+			defer cancel()
+			start := time.Now()
+			err := fn(octx)
+			w.record(op, time.Since(start), err)
+		}()
+	}
+	wg.Wait()
+}
+
+func (w *Workload) record(op string, d time.Duration, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	s, ok := w.ops[op]
+	if !ok {
+		s = &OpStats{Op: op}
+		w.ops[op] = s
+	}
+	s.Ops++
+	if err != nil {
+		s.Failures++
+	}
+	s.Latency.AddDuration(d)
+}
+
+// Results returns the stats of each operation type run so far.
+func (w *Workload) Results() []*OpStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var rs []*OpStats
+	for _, s := range w.ops {
+		rs = append(rs, s)
+	}
+	sort.Slice(rs, func(i, j int) bool { return rs[i].Op < rs[j].Op })
+	return rs
+}
+
+func PrintResults(wr io.Writer, rs []*OpStats) {
+	for _, s := range rs {
+		fmt.Fprintf(wr, "%s: %d ops, %.1f%% success\n", s.Op, s.Ops, 100*s.SuccessRate())
+		fmt.Fprintf(wr, "  latency %v\n", s.Latency.Summary().DurationString())
+	}
+}
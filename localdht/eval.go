@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"time"
+
+	dhtnode "github.com/libp2p/dht-tracer1/lib/dhtnode"
+	dhtquery "github.com/libp2p/dht-tracer1/lib/dhtquery"
+	stats "github.com/libp2p/dht-tracer1/lib/stats"
+	peer "github.com/libp2p/go-libp2p-core/peer"
+)
+
+// evalK is the kad-dht default bucket size, which is also how many peers
+// a lookup converges on.
+const evalK = 20
+
+const evalTimeout = time.Minute
+
+var evalOps = []string{"get-closest-peers", "find-peer", "get-value"}
+
+type evalResult struct {
+	Op       string
+	Failures int
+	Recall   stats.Dist
+	Hops     stats.Dist
+	Messages stats.Dist
+	Latency  stats.Dist
+}
+
+// runEval runs lookups from randomly sampled nodes and compares them with
+// the true k closest nodes, which localdht knows since it runs them all.
+func runEval(w io.Writer, net *dhtnode.Net, ops []string, lookups int) error {
+	if len(net.Nodes) < 2 {
+		return errors.New("lookup evaluation needs at least 2 nodes")
+	}
+
+	fmt.Fprintf(w, "lookup evaluation: %d lookups per op, ground truth k=%d\n", lookups, evalK)
+	for _, op := range ops {
+		r := &evalResult{Op: op}
+		for i := 0; i < lookups; i++ {
+			err := evalLookup(net, r)
+			if err != nil {
+				log.Debugf("eval %s failed: %s", op, err)
+				r.Failures++
+			}
+		}
+		printEvalResult(w, r, lookups)
+	}
+	return nil
+}
+
+func printEvalResult(w io.Writer, r *evalResult, lookups int) {
+	fmt.Fprintf(w, "%s: %d lookups, %d failed\n", r.Op, lookups, r.Failures)
+	fmt.Fprintf(w, "  recall   %v\n", r.Recall.Summary())
+	fmt.Fprintf(w, "  hops     %v\n", r.Hops.Summary())
+	fmt.Fprintf(w, "  messages %v\n", r.Messages.Summary())
+	fmt.Fprintf(w, "  latency  %v\n", r.Latency.Summary().DurationString())
+}
+
+// evalLookup runs a single r.Op lookup and adds its measurements to r.
+// Recall is measured against the peers a get-closest-peers lookup returned,
+// and against the peers that responded for the other lookups.
+func evalLookup(net *dhtnode.Net, r *evalResult) error {
+	ctx, cancel := context.WithTimeout(context.Background(), evalTimeout)
+	defer cancel()
+
+	src, dst := sampleNodes(net)
+	var (
+		key   string
+		found []peer.ID
+		t     *dhtquery.Trace
+		err   error
+	)
+
+	switch r.Op {
+	case "get-closest-peers":
+		key = randKey()
+		var qctx context.Context
+		qctx, t = dhtquery.Record(ctx, src.ID(), r.Op, key, key)
+		found, err = src.DHT.GetClosestPeers(qctx, key)
+		t.Finish(err)
+	case "find-peer":
+		key = string(dst.ID())
+		var qctx context.Context
+		qctx, t = dhtquery.Record(ctx, src.ID(), r.Op, dst.ID().String(), key)
+		_, err = src.DHT.FindPeer(qctx, dst.ID())
+		t.Finish(err)
+	case "get-value":
+		key = randKey()
+		val := []byte(key)
+		if err := dst.DHT.PutValue(ctx, key, val); err != nil {
+			return fmt.Errorf("put-value setup: %w", err)
+		}
+
+		var qctx context.Context
+		var got []byte
+		qctx, t = dhtquery.Record(ctx, src.ID(), r.Op, key, key)
+		got, err = src.DHT.GetValue(qctx, key)
+		t.Finish(err)
+		if err == nil && !bytes.Equal(got, val) {
+			err = errors.New("got wrong value")
+		}
+	default:
+		return fmt.Errorf("unknown eval op: %s", r.Op)
+	}
+
+	if r.Op != "get-closest-peers" {
+		found = t.Responded()
+	}
+	truth := net.ClosestPeers(key, evalK, src.ID())
+	r.Recall.Add(recall(found, truth))
+	r.Hops.Add(float64(t.MaxHops()))
+	r.Messages.Add(float64(t.Messages))
+	r.Latency.AddDuration(t.Duration())
+	return err
+}
+
+func recall(found, truth []peer.ID) float64 {
+	if len(truth) == 0 {
+		return 1
+	}
+
+	in := map[peer.ID]bool{}
+	for _, p := range found {
+		in[p] = true
+	}
+	hits := 0
+	for _, p := range truth {
+		if in[p] {
+			hits++
+		}
+	}
+	return float64(hits) / float64(len(truth))
+}
+
+// sampleNodes picks two distinct random nodes.
+func sampleNodes(net *dhtnode.Net) (a, b *dhtnode.Node) {
+	perm := rand.Perm(len(net.Nodes))
+	return net.Nodes[perm[0]], net.Nodes[perm[1]]
+}
+
+func randKey() string {
+	return fmt.Sprintf("/v/eval-%x", rand.Uint64())
+}
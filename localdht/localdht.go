@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"io"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	logging "github.com/ipfs/go-log"
+	dhttracer "github.com/libp2p/dht-tracer1/lib"
 	dhtnode "github.com/libp2p/dht-tracer1/lib/dhtnode"
 	peer "github.com/libp2p/go-libp2p-core/peer"
 )
@@ -26,17 +30,45 @@ OPTIONS
     --debug           enable debug logging
     --quic            use quic transport only (helps w/ fd limits)
     --bootstrap-file  write bootstrap addresses to this file
+    --repl            read commands from stdin (see COMMANDS)
+    --eval <int>      run <int> lookups per op, and compare with ground truth
+    --eval-ops <ops>  comma separated lookups to evaluate
+                      (default: get-closest-peers,find-peer,get-value)
+    --metrics <addr>  serve prometheus metrics of all nodes at
+                      http://<addr>/metrics
+    --validators <nss>
+                      record namespaces the nodes accept. see tracedht
+                      --help (default: "ipns;v")
 
 EXAMPLES
     # run 100 dht nodes
     localdht
+
+    # check where a value ends up
+    localdht --repl
+    > put-value /v/foo bar
+    > placement /v/foo
+
+    # measure lookup accuracy over 50 lookups of each type
+    localdht -n 200 --eval 50
+
+    # scrape the network's metrics
+    localdht --metrics localhost:9100 &
+    curl http://localhost:9100/metrics
 `
 
+var log = logging.Logger("localdht")
+
 type Opts struct {
 	BootstrapFile string
 	NumNodes      int
 	Debug         bool
 	Quic          bool
+	Repl          bool
+	EvalLookups   int
+	EvalOps       []string
+	MetricsAddr   string
+	Validators    dhtnode.ValidatorCfg
 }
 
 func parseOpts() (Opts, []string) {
@@ -44,12 +76,22 @@ func parseOpts() (Opts, []string) {
 	flag.IntVar(&o.NumNodes, "n", 100, "number of dht nodes to run")
 	flag.BoolVar(&o.Debug, "debug", false, "enable debug logging")
 	flag.BoolVar(&o.Quic, "quic", false, "use quic only")
+	flag.BoolVar(&o.Repl, "repl", false, "read commands from stdin")
 	flag.StringVar(&o.BootstrapFile, "bootstrap-file", "", "bootstrap file")
+	flag.IntVar(&o.EvalLookups, "eval", 0, "lookups per op to evaluate")
+	flag.StringVar(&o.MetricsAddr, "metrics", "", "prometheus metrics address")
+	flag.Func("validators", "record namespaces to accept", func(s string) (err error) {
+		o.Validators, err = dhtnode.ParseValidatorCfg(s)
+		return err
+	})
+	evalOpsStr := flag.String("eval-ops", strings.Join(evalOps, ","), "lookups to evaluate")
 	flag.Usage = func() {
 		fmt.Fprintln(os.Stderr, Usage)
+		fmt.Fprintln(os.Stderr, ReplUsage)
 	}
 	flag.Parse()
 	args := flag.Args()
+	o.EvalOps = strings.Split(*evalOpsStr, ",")
 	return o, args
 }
 
@@ -86,23 +128,99 @@ func runDHTNet(opts Opts) error {
 
 	net.Bootstrap()
 
-	// wait for termination. periodically print stats.
+	var metrics *http.Server
+	if opts.MetricsAddr != "" {
+		metrics, err = serveMetrics(net, opts.MetricsAddr)
+		if err != nil {
+			closeNet(net, nil)
+			return err
+		}
+	}
+
+	if opts.EvalLookups > 0 {
+		err = runEval(os.Stdout, net, opts.EvalOps, opts.EvalLookups)
+		if err != nil {
+			closeNet(net, metrics)
+			return err
+		}
+	}
+
+	replDone := make(chan struct{})
+	if opts.Repl {
+		go func() {
+			defer close(replDone)
+			NewRepl(net, os.Stdout).Run(os.Stdin)
+		}()
+	}
+
+	// wait for termination. periodically print stats, unless the repl
+	// is using the terminal.
 	terminate := termSignalChan()
 	for {
-		dhtnode.PrintNodeStats(os.Stdout, net.Nodes)
+		if !opts.Repl {
+			dhtnode.PrintNodeStats(os.Stdout, net.Nodes)
+		}
 
 		select {
 		case <-time.After(time.Second * 10):
+		case <-replDone:
+			fmt.Println("exiting...")
+			return closeNet(net, metrics)
 		case <-terminate:
 			fmt.Println("exiting...")
-			return nil
+			return closeNet(net, metrics)
 		}
 	}
 }
 
+// closeNet stops the metrics server, if any, and closes all nodes. A
+// second signal exits at once.
+func closeNet(net *dhtnode.Net, metrics *http.Server) error {
+	go func() {
+		<-termSignalChan()
+		fmt.Fprintln(os.Stderr, "forced exit")
+		os.Exit(1)
+	}()
+
+	if metrics != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := metrics.Shutdown(ctx); err != nil {
+			log.Error("metrics server:", err)
+		} else {
+			fmt.Println("closed metrics server")
+		}
+	}
+
+	if err := net.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("closed %d nodes: dhts, hosts and datastores\n", len(net.Nodes))
+	return nil
+}
+
+func serveMetrics(net *dhtnode.Net, addr string) (*http.Server, error) {
+	h, err := dhttracer.MetricsHandler(func() []*dhtnode.Node { return net.Nodes })
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", h)
+	s := &http.Server{Addr: addr, Handler: mux}
+
+	fmt.Println("serving metrics at", addr)
+	go func() {
+		if err := s.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Error("metrics server:", err)
+		}
+	}()
+	return s, nil
+}
+
 func nodeCfgWithOpts(opts Opts) dhtnode.NodeCfg {
 	cfg := dhtnode.DefaultNodeCfg()
 	cfg.Bootstrap = nil // dont use any bootstrap addrs here
+	cfg.Validators = opts.Validators
 
 	if opts.Quic {
 		cfg.Libp2pOpts = dhtnode.Libp2pOptionsQUIC()
@@ -114,6 +232,7 @@ func nodeCfgWithOpts(opts Opts) dhtnode.NodeCfg {
 func errMain(opts Opts, _ []string) error {
 	if opts.Debug {
 		logging.SetLogLevel("tracedhtnode", "debug")
+		logging.SetLogLevel("localdht", "debug")
 	}
 
 	return runDHTNet(opts)
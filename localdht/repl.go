@@ -1,30 +1,224 @@
 package main
 
-// type Repl struct {
-//   rw   io.ReadWriter
-//   net  dhtnode.Net
-//   cmds map[string]func()
-// }
-
-// func NewRepl(net *dhtnode.Net) *Repl {
-//   repl := &Repl{}
-//   repl.net = net
-//   repl.cmds := map[string]func(){
-//     "stats": repl.Stats
-//   }
-
-// }
-
-// func (repl *Repl) Dispatch(line string) error {
-
-//   cmd := strings.Split(line, " ")
-//   if len(cmd) < 1 {
-//     return nil
-//   }
-
-//   switch cmd[0] {
-//   case "stats":
-//   case "bootstrap":
-//   case "exit":
-//   }
-// }
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	cid "github.com/ipfs/go-cid"
+	dhttracer "github.com/libp2p/dht-tracer1/lib"
+	dhtnode "github.com/libp2p/dht-tracer1/lib/dhtnode"
+	workload "github.com/libp2p/dht-tracer1/lib/workload"
+)
+
+var ReplUsage = `COMMANDS
+    stats                    print node stats
+    put-value <key> <value>  put a value from a random node
+    get-value <key>          get a value from a random node
+    add-provider <cid>       provide a cid from a random node
+    get-providers <cid>      find providers of a cid from a random node
+    placement <key|cid>      list which nodes store the record for a key
+    eval <int> [<ops>]       run a lookup accuracy evaluation
+    workload [<opt>=<val>]   populate the network with records and read them.
+                             opts: values providers reads dist zipf-s rate
+                             concurrency timeout
+    help                     show this help
+    exit                     stop the network
+
+    queries take tracedht's options before the key: --quorum=<int>,
+    --offline, --timeout=<duration>, --max-providers=<int>, and
+    --key-encoding, --value-encoding and --output, which are text,
+    base64 or hex, e.g.
+    > get-value --quorum=3 /v/foo
+    > put-value --value-encoding=hex /v/foo cafe00
+`
+
+var errReplExit = errors.New("exit")
+
+type Repl struct {
+	w   io.Writer
+	net *dhtnode.Net
+}
+
+func NewRepl(net *dhtnode.Net, w io.Writer) *Repl {
+	return &Repl{w: w, net: net}
+}
+
+// Run reads commands from r until exit or EOF.
+func (repl *Repl) Run(r io.Reader) {
+	fmt.Fprint(repl.w, ReplUsage)
+	s := bufio.NewScanner(r)
+	for fmt.Fprint(repl.w, "> "); s.Scan(); fmt.Fprint(repl.w, "> ") {
+		err := repl.Dispatch(s.Text())
+		if err == errReplExit {
+			return
+		}
+		if err != nil {
+			fmt.Fprintln(repl.w, "error:", err)
+		}
+	}
+}
+
+func (repl *Repl) Dispatch(line string) error {
+	args := strings.Fields(line)
+	if len(args) < 1 {
+		return nil
+	}
+
+	cmd := args[0]
+	opts, args, err := dhttracer.ParseQueryOpts(args)
+	if err != nil {
+		return err
+	}
+	if cmdTakesOpts(cmd) {
+		if err := opts.Check(cmd); err != nil {
+			return err
+		}
+	} else if opts != (dhttracer.QueryOpts{}) {
+		return fmt.Errorf("%v takes no options", cmd)
+	}
+
+	timeout := time.Minute
+	if opts.Timeout > 0 {
+		timeout = opts.Timeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	switch cmd {
+	case "stats":
+		dhtnode.PrintNodeStats(repl.w, repl.net.Nodes)
+	case "put-value":
+		if len(args) != 3 {
+			return errors.New("usage: put-value [<opts>] <key> <value>")
+		}
+		q := dhttracer.Query{Cmd: cmd, Key: args[1], Args: args[2:], Opts: opts}
+		key, err := q.DecodedKey()
+		if err != nil {
+			return err
+		}
+		val, err := q.DecodedValue()
+		if err != nil {
+			return err
+		}
+		n := repl.randomNode()
+		if err := n.DHT.PutValue(ctx, key, val); err != nil {
+			return err
+		}
+		fmt.Fprintf(repl.w, "put %v %v (%d bytes) from %v\n", args[1], args[2], len(val), n)
+	case "get-value":
+		if len(args) != 2 {
+			return errors.New("usage: get-value [<opts>] <key>")
+		}
+		q := dhttracer.Query{Cmd: cmd, Key: args[1], Opts: opts}
+		key, err := q.DecodedKey()
+		if err != nil {
+			return err
+		}
+		n := repl.randomNode()
+		val, err := n.DHT.GetValue(ctx, key, opts.RoutingOptions()...)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(repl.w, "got %v %s from %v\n", args[1], dhttracer.Encode(opts.Output, val), n)
+	case "add-provider":
+		if len(args) != 2 {
+			return errors.New("usage: add-provider [<opts>] <cid>")
+		}
+		c, err := cid.Decode(args[1])
+		if err != nil {
+			return err
+		}
+		n := repl.randomNode()
+		if err := n.DHT.Provide(ctx, c, !opts.Offline); err != nil {
+			return err
+		}
+		fmt.Fprintf(repl.w, "added %v as provider for %v\n", n, c)
+	case "get-providers":
+		if len(args) != 2 {
+			return errors.New("usage: get-providers [<opts>] <cid>")
+		}
+		c, err := cid.Decode(args[1])
+		if err != nil {
+			return err
+		}
+		max := opts.MaxProviders
+		if max == 0 {
+			max = dhttracer.DefaultMaxProviders
+		}
+		n := repl.randomNode()
+		var found int
+		if opts.Offline {
+			for _, p := range n.DHT.ProviderManager.GetProviders(ctx, c.Hash()) {
+				if found == max {
+					break
+				}
+				fmt.Fprintln(repl.w, p)
+				found++
+			}
+		} else {
+			for ai := range n.DHT.FindProvidersAsync(ctx, c, max) {
+				fmt.Fprintln(repl.w, ai.ID)
+				found++
+			}
+		}
+		fmt.Fprintf(repl.w, "found %d providers of %v from %v\n", found, c, n)
+	case "placement":
+		if len(args) != 2 {
+			return errors.New("usage: placement <key|cid>")
+		}
+		p, err := repl.net.Placement(ctx, args[1], evalK)
+		if err != nil {
+			return err
+		}
+		dhtnode.PrintPlacement(repl.w, p)
+	case "eval":
+		if len(args) < 2 {
+			return errors.New("usage: eval <int> [<ops>]")
+		}
+		lookups, err := strconv.Atoi(args[1])
+		if err != nil {
+			return err
+		}
+		ops := evalOps
+		if len(args) > 2 {
+			ops = strings.Split(args[2], ",")
+		}
+		return runEval(repl.w, repl.net, ops, lookups)
+	case "workload":
+		cfg, err := workload.ParseArgs(args[1:])
+		if err != nil {
+			return err
+		}
+		w := workload.New(cfg)
+		err = w.Run(context.Background(), repl.net.Nodes)
+		workload.PrintResults(repl.w, w.Results())
+		return err
+	case "help":
+		fmt.Fprint(repl.w, ReplUsage)
+	case "exit":
+		return errReplExit
+	default:
+		return fmt.Errorf("unrecognized command: %v", args[0])
+	}
+	return nil
+}
+
+// cmdTakesOpts returns whether cmd is a query, that takes QueryOpts.
+func cmdTakesOpts(cmd string) bool {
+	switch cmd {
+	case "put-value", "get-value", "add-provider", "get-providers":
+		return true
+	}
+	return false
+}
+
+func (repl *Repl) randomNode() *dhtnode.Node {
+	return repl.net.Nodes[rand.Intn(len(repl.net.Nodes))]
+}
@@ -1,17 +1,25 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	logging "github.com/ipfs/go-log"
 	dhttracer "github.com/libp2p/dht-tracer1/lib"
 	dhtnode "github.com/libp2p/dht-tracer1/lib/dhtnode"
+	dhtquery "github.com/libp2p/dht-tracer1/lib/dhtquery"
+	otlp "github.com/libp2p/dht-tracer1/lib/otlp"
+	tabular "github.com/libp2p/dht-tracer1/lib/tabular"
 	peer "github.com/libp2p/go-libp2p-core/peer"
-	dht "github.com/libp2p/go-libp2p-kad-dht"
 )
 
 var Usage = `SYNOPSIS
@@ -23,12 +31,64 @@ USAGE
 OPTIONS
     -h, --help           show usage
     --serve <addr>       run ctrl http server on <addr>
-    --kad-alpha <int>    set kad-dht alpha value (default: 10)
+                         (default: localhost:7000)
+    --auth-token <tok>   require <tok> of ctrl server clients, as a bearer
+                         token, ?token= or cookie (default: $TRACEDHT_TOKEN,
+                         or none)
+    --read-only          refuse commands that change the tracer or write to
+                         the dht: exit, reset, put-value, add-provider,
+                         ipns-publish and workloads (default: false)
+    --tls-cert <file>    serve https with this cert. needs --tls-key
+                         (default: none, plain http)
+    --tls-key <file>     key of --tls-cert (default: none)
+    --alpha <int>        set kad-dht alpha value (default: 10)
+    --bucket-size <int>  set kad-dht bucket size (k). the public dht only
+                         allows 20, see dhtexp for other sizes
+    --beta <int>         set kad-dht resiliency (beta)
+    --mode <mode>        set kad-dht mode: auto, auto-server, client, server
+    --validators <nss>   record namespaces to accept, ';' delimited, each
+                         "ipns" or <ns>[:<opt>,...]. opts: schema=any|utf8|
+                         json|json-seq, select=first|highest-seq|lexical,
+                         max-size=<bytes>. /pk/ is always accepted
+                         (default: "ipns;v", anything under /v/)
     --bootstrap <addrs>  non-default bootstrap multiaddrs (newline delimited)
     --debug              enable debug logs
     --quic               use quic transport only (helps with fd limits)
+    --keystore <dir>     keep the keys ipns-publish makes in <dir>, instead
+                         of in memory
     #todo -f, --logfile  file to store eventlogs in
 
+COMPARING DHT CONFIGURATIONS
+    --compare <variants> run --queries through a node per variant, print
+                         a side-by-side comparison, and exit. variants are
+                         ';' separated lists of params, which are applied on
+                         top of the options above, e.g.
+                         "alpha=3;alpha=10,beta=5;beta=5,mode=client"
+    --queries <file>     queries to run, one per line
+    --rounds <int>       times to run the queries (default: 1)
+
+TRACE EXPORT
+    --export <table>       write stored traces given as arguments as a
+                           table to stdout, and exit. tables: queries (a
+                           row per query), peers (a row per query, peer)
+    --format <fmt>         export format: csv (default) or columnar (json
+                           with a value array per column)
+    --filter <k=v>         export only matching queries. keys: id, cmd,
+                           since, until. times are RFC3339, or durations
+                           before now. may be repeated
+    --graph <fmt>          write the lookup graph of the stored trace that
+                           --filter selects to stdout, and exit. formats:
+                           dot, svg
+    --otlp <url>           send every query as an OpenTelemetry trace to
+                           an OTLP/HTTP collector, e.g.
+                           http://localhost:4318/v1/traces
+    --otlp-header <k=v>    header to send to the collector, e.g. for auth.
+                           may be repeated
+    --chrome-trace <file>  convert stored traces (json, one per line, as
+                           dhtexp writes them) given as arguments into
+                           Chrome trace event json, and exit. open the
+                           result in ui.perfetto.dev or chrome://tracing
+
 QUERIES
     Please see the documentation for libp2p-kad-dht to find out
     what dht queries mean and do. This tool assumes extensive
@@ -43,6 +103,38 @@ QUERIES
       get-providers <cid>
       find-peer <peer-id>
       ping <peer-id>
+      get-closest-peers <key>
+      search-value <key>
+      ipns-publish <key-name> <path>
+      ipns-resolve <name>
+
+    ipns-publish signs a record of <path> (/ipfs/<cid>, /ipns/<name> or a
+    cid) with the key <key-name>, made if there is none, and puts it at
+    /ipns/<peer-id of the key>. The key "self" is the node's own.
+    Sequence numbers count up from the last record published.
+    ipns-resolve gets and validates the record of <name>: a peer id,
+    /ipns/<peer-id>, or a key name.
+
+    Queries take options before the key:
+
+      --quorum=<int>         responses get-value, search-value and
+                             ipns-resolve wait for (default: kad-dht's, 16)
+      --offline              only use the node's datastore, provider store
+                             and peerstore: get-value, search-value,
+                             add-provider, get-providers, find-peer,
+                             ipns-resolve
+      --timeout=<duration>   cancel the query after <duration>, e.g. 10s
+      --max-providers=<int>  providers get-providers looks for (default: 10)
+      --lifetime=<duration>  how long ipns-publish records are valid
+                             (default: 24h)
+      --ttl=<duration>       how long resolvers may cache ipns-publish
+                             records (default: 1h)
+      --key-encoding=<enc>   encoding of the key: text (default), base64
+                             or hex
+      --value-encoding=<enc> encoding of put-value's value
+      --value-file=<file>    put-value's value is the file's content
+                             (commandline only. over http, POST it)
+      --output=<enc>         encoding of get-value and search-value values
 
     Queries can be run via the commandline, or via an api server
     that this tool runs.
@@ -53,38 +145,193 @@ EXAMPLES
     tracedht --serve ":8080"
 
     # run dht queries w/ alpha value of 15
-    tracedht --kad-alpha 15
+    tracedht --alpha 15
+
+    # trace versioned json records under /app/, besides the default ones
+    tracedht --validators "ipns;v;app:schema=json-seq,select=highest-seq"
+
+    # compare alpha values over a set of queries
+    tracedht --compare "alpha=3;alpha=10;alpha=20" --queries queries.txt --rounds 5
+
+    # run a specific query, print its result and trace, and then exit
+    tracedht find-peer <peer-id>
+
+    # every better version of a record, as it is found: elapsed time,
+    # a guess at the peer that supplied it, and value. waits for 5
+    # responses
+    tracedht search-value --quorum=5 /v/foo
+    curl -N "http://localhost:8080/cmd?q=search-value+--quorum=5+/v/foo"
 
-    # run a specific query, and then Exit
-    tracedht find-peer
+    # query options, in the cli, over http and in the json api
+    tracedht get-providers --max-providers=50 --timeout=30s <cid>
+    curl "http://localhost:8080/cmd?q=get-value+--offline+/v/foo"
+    curl -X POST "http://localhost:8080/api/v1/get-value" -d '{"key": "/v/foo", "quorum": 3, "timeout": "10s"}'
 
-    # server example
+    # the k closest peers to a key, with their xor distances. no records
+    # are read or stored
+    tracedht get-closest-peers /v/foo
+
+    # server example. open http://localhost:8080 for the web ui
     tracedht --serve :8080 &
     curl "http://localhost:8080/cmd?q=put-value+foo+bar"
     curl "http://localhost:8080/cmd?q=find-peer+<peer-id>"
 
-    # save event logs
+    # binary records: from a file, as hex, or as a POST body
+    tracedht put-value --value-file=record.bin /v/foo
+    tracedht get-value --output=base64 /v/foo
+    curl "http://localhost:8080/cmd?q=put-value+--value-encoding=hex+/v/foo+cafe00"
+    curl -H "Content-Type: application/octet-stream" --data-binary @record.bin \
+      "http://localhost:8080/cmd?q=put-value+/v/foo"
+    curl -X POST "http://localhost:8080/api/v1/get-value" -d '{"key": "/v/foo", "output": "hex"}'
+
+    # publish and resolve ipns records, keeping the key between runs
+    tracedht --keystore ~/.tracedht/keys ipns-publish --ttl=10m mykey /ipfs/<cid>
+    tracedht --keystore ~/.tracedht/keys ipns-resolve mykey
+    curl -X POST "http://localhost:8080/api/v1/ipns-resolve" -d '{"name": "/ipns/<peer-id>"}'
+
+    # the json api. its openapi description is at /api/v1/openapi.json
+    curl -X POST "http://localhost:8080/api/v1/put-value" -d '{"key": "/v/foo", "value": "a b+c"}'
+    curl -X POST "http://localhost:8080/api/v1/get-providers" -d '{"cid": "<cid>"}'
+
+    # stop: drains running queries for up to 30s, flushes trace sinks, and
+    # closes the node. a second ctrl-c exits at once
+    curl "http://localhost:8080/cmd?q=exit"
+
+    # a shared tracer: require a token, refuse exit, reset, put-value and
+    # add-provider, and serve https
+    TRACEDHT_TOKEN=s3cret tracedht --serve :8443 --read-only --tls-cert cert.pem --tls-key key.pem
+    curl -H "Authorization: Bearer s3cret" "https://lab:8443/cmd?q=get-value+foo"
+    # in a browser, open https://lab:8443/?token=s3cret once, for a cookie
+
+    # put 50 values and 50 provider records, then read them 500 times
+    curl "http://localhost:8080/workload?values=50&providers=50&reads=500&dist=zipf"
+
+    # load test: 20 concurrent find-peer queries at 50 qps, for 30s
+    curl "http://localhost:8080/loadtest?q=find-peer+<peer-id>&concurrency=20&qps=50&duration=30s"
+
+    # list recent query traces, and export the latest for perfetto
+    curl "http://localhost:8080/traces"
+    curl "http://localhost:8080/trace?format=chrome" >query.json
+
+    # per-peer rows of get-value queries, for a notebook
+    tracedht --export peers --filter cmd=get-value traces-alpha3.jsonl >peers.csv
+    curl "http://localhost:8080/export?table=peers&format=columnar&since=10m"
+
+    # graph the shape of a lookup
+    tracedht --graph svg --filter id=<query-id> traces-alpha3.jsonl >lookup.svg
+    curl "http://localhost:8080/trace?id=<query-id>&format=dot" | dot -Tpng >lookup.png
+
+    # send query traces to a local opentelemetry collector
+    tracedht --serve :8080 --otlp http://localhost:4318/v1/traces
+
+    # export stored experiment traces for perfetto
+    tracedht --chrome-trace lookups.json results/alpha/traces-alpha3.jsonl
+
+    # scrape prometheus metrics
+    curl "http://localhost:8080/metrics"
+
+    # follow a query's events, as json lines
+    curl "http://localhost:8080/events?subsystem=query,dht&type=peer-response,query-end"
+    curl "http://localhost:8080/events?query=<query-id>&format=sse"
+
+    # live feed of query and per-peer rows, for the visualizer
+    websocat "ws://localhost:8080/vis/ws"
+
+    # save raw event logs
     tracedht --serve :8080 &
-    curl "http://localhost:8080/events" | grep dht >eventlogs
+    curl "http://localhost:8080/eventlog" | grep dht >eventlogs
     curl "http://localhost:8080/cmd?q=put-value+foo+bar"
 `
 
 type Opts struct {
 	Debug          bool
 	ServerAddr     string
-	KadAlpha       int
+	AuthToken      string
+	ReadOnly       bool
+	TLSCert        string
+	TLSKey         string
+	DhtParams      dhtnode.DhtParams
+	Validators     dhtnode.ValidatorCfg
 	BootstrapStr   string
 	BootstrapAddrs []*peer.AddrInfo
 	Quic           bool
+	Keystore       string
+
+	Compare     string
+	QueriesFile string
+	Rounds      int
+
+	ChromeTrace  string
+	Export       string
+	ExportFormat string
+	ExportFilter filterFlags
+	Graph        string
+	OTLP         string
+	OTLPHeaders  headerFlags
+}
+
+// filterFlags is a repeatable "key=value" flag, setting tabular filters.
+type filterFlags struct {
+	tabular.Filter
+}
+
+func (f *filterFlags) String() string {
+	return fmt.Sprint(f.Filter)
+}
+
+func (f *filterFlags) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("filter must be key=value: %v", s)
+	}
+	return f.Filter.Set(kv[0], kv[1])
+}
+
+// headerFlags is a repeatable "key=value" flag.
+type headerFlags map[string]string
+
+func (h headerFlags) String() string {
+	return fmt.Sprint(map[string]string(h))
+}
+
+func (h headerFlags) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return fmt.Errorf("header must be key=value: %v", s)
+	}
+	h[kv[0]] = kv[1]
+	return nil
 }
 
 func parseOpts() (Opts, []string, error) {
-	var o Opts
+	o := Opts{OTLPHeaders: headerFlags{}}
 	flag.BoolVar(&o.Debug, "debug", false, "enable debug logging")
 	flag.BoolVar(&o.Quic, "quic", false, "quic transport only")
+	flag.StringVar(&o.Keystore, "keystore", "", "ipns key directory")
 	flag.StringVar(&o.BootstrapStr, "bootstrap", "", "non default bootstrap addrs to use")
 	flag.StringVar(&o.ServerAddr, "serve", "localhost:7000", "http address for ctrl server")
-	flag.IntVar(&o.KadAlpha, "alpha", 10, "alpha value for kad-dht")
+	flag.StringVar(&o.AuthToken, "auth-token", os.Getenv("TRACEDHT_TOKEN"), "bearer token for ctrl server")
+	flag.BoolVar(&o.ReadOnly, "read-only", false, "refuse mutating commands")
+	flag.StringVar(&o.TLSCert, "tls-cert", "", "tls cert file for ctrl server")
+	flag.StringVar(&o.TLSKey, "tls-key", "", "tls key file for ctrl server")
+	flag.IntVar(&o.DhtParams.Alpha, "alpha", 10, "alpha value for kad-dht")
+	flag.IntVar(&o.DhtParams.BucketSize, "bucket-size", 0, "bucket size for kad-dht")
+	flag.IntVar(&o.DhtParams.Beta, "beta", 0, "resiliency for kad-dht")
+	flag.StringVar(&o.DhtParams.Mode, "mode", "", "kad-dht mode")
+	flag.Func("validators", "record namespaces to accept", func(s string) (err error) {
+		o.Validators, err = dhtnode.ParseValidatorCfg(s)
+		return err
+	})
+	flag.StringVar(&o.Compare, "compare", "", "dht param variants to compare")
+	flag.StringVar(&o.QueriesFile, "queries", "", "file of queries")
+	flag.IntVar(&o.Rounds, "rounds", 1, "times to run the queries")
+	flag.StringVar(&o.ChromeTrace, "chrome-trace", "", "chrome trace output file")
+	flag.StringVar(&o.Export, "export", "", "table to export")
+	flag.StringVar(&o.ExportFormat, "format", tabular.FormatCSV, "export format")
+	flag.Var(&o.ExportFilter, "filter", "export filter")
+	flag.StringVar(&o.Graph, "graph", "", "lookup graph format")
+	flag.StringVar(&o.OTLP, "otlp", "", "otlp/http collector url")
+	flag.Var(o.OTLPHeaders, "otlp-header", "otlp collector header")
 	flag.Usage = func() {
 		fmt.Fprintln(os.Stderr, Usage)
 	}
@@ -102,11 +349,28 @@ func parseOpts() (Opts, []string, error) {
 		o.BootstrapAddrs = ais
 	}
 
+	if err := o.DhtParams.CheckPublic(); err != nil {
+		return o, args, err
+	}
+
+	if (o.TLSCert == "") != (o.TLSKey == "") {
+		return o, args, errors.New("--tls-cert and --tls-key go together")
+	}
+
 	return o, args, nil
 }
 
-func setupTracer(cfg dhtnode.NodeCfg) (*dhttracer.Tracer, error) {
+func setupTracer(cfg dhtnode.NodeCfg, opts Opts) (*dhttracer.Tracer, error) {
 	t := dhttracer.NewTracer(cfg)
+	if opts.Keystore != "" {
+		t.Keys = dhttracer.NewKeystore(opts.Keystore)
+	}
+	if opts.OTLP != "" {
+		e := otlp.NewExporter(opts.OTLP, "tracedht")
+		e.Headers = opts.OTLPHeaders
+		t.Sinks = append(t.Sinks, e)
+		fmt.Println("exporting traces to", opts.OTLP)
+	}
 	fmt.Println("dht node starting...")
 	if err := t.Start(); err != nil {
 		return nil, err
@@ -116,16 +380,256 @@ func setupTracer(cfg dhtnode.NodeCfg) (*dhttracer.Tracer, error) {
 	// (no nice way to listen for an event yet)
 	time.Sleep(time.Second * 5)
 	fmt.Println("dht node routing table:")
-	fmt.Println(t.Node.RoutingTable())
+	io.Copy(os.Stdout, t.Node.RoutingTable())
 	return t, nil
 }
 
-func runTracerServer(t *dhttracer.Tracer, addr string) error {
-	s := dhttracer.NewHTTPServer(t, addr)
+func runTracerServer(t *dhttracer.Tracer, opts Opts) error {
+	s := dhttracer.NewHTTPServer(t, opts.ServerAddr)
+	s.Token = opts.AuthToken
+	s.ReadOnly = opts.ReadOnly
+	if s.Token == "" && !isLoopback(opts.ServerAddr) {
+		fmt.Fprintln(os.Stderr, "warning: serving on", opts.ServerAddr, "without --auth-token")
+	}
+	if s.ReadOnly {
+		fmt.Println("read-only: mutating commands are disabled")
+	}
+
+	// shut down gracefully on a signal, or at once on a second one.
+	go func() {
+		sigc := make(chan os.Signal, 2)
+		signal.Notify(sigc, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+		<-sigc
+		go func() {
+			<-sigc
+			fmt.Fprintln(os.Stderr, "forced exit")
+			os.Exit(1)
+		}()
+
+		ctx, cancel := context.WithTimeout(context.Background(), dhttracer.ShutdownTimeout)
+		defer cancel()
+		s.Shutdown(ctx)
+	}()
+
+	if opts.TLSCert != "" {
+		fmt.Println("server listening at https://" + s.Server.Addr)
+		return s.ListenAndServeTLS(opts.TLSCert, opts.TLSKey) // hangs till done
+	}
 	fmt.Println("server listening at", s.Server.Addr)
 	return s.ListenAndServe() // hangs till done
 }
 
+// runCLIQuery runs the query given as arguments, prints its result and
+// trace, and stops the tracer.
+func runCLIQuery(t *dhttracer.Tracer, args []string) error {
+	// --value-file=<file> is put-value's value.
+	var valueFile string
+	for i, a := range args {
+		if strings.HasPrefix(a, "--value-file=") {
+			valueFile = strings.TrimPrefix(a, "--value-file=")
+			args = append(args[:i:i], args[i+1:]...)
+			break
+		}
+	}
+
+	if len(args) < 1 {
+		return errors.New("no query")
+	}
+	q, err := dhttracer.NewQuery(args[0], args[1:])
+	if err != nil {
+		return err
+	}
+	if valueFile != "" {
+		buf, err := os.ReadFile(valueFile)
+		if err != nil {
+			return err
+		}
+		if err := q.SetValue(buf); err != nil {
+			return err
+		}
+	}
+
+	// search-value prints records as it finds them.
+	streamed := q.Cmd == dhttracer.CmdSearchValue
+	if streamed {
+		q.OnRecord = func(r dhttracer.SearchRecord) { fmt.Println(r.Text(q.Opts.Output)) }
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	res, err := t.Run(ctx, q)
+	if res != nil {
+		if !streamed {
+			io.Copy(os.Stdout, res.Out)
+			fmt.Println()
+		}
+		if v, ok := res.Value.([]byte); ok {
+			fmt.Printf("(%d bytes)\n", len(v))
+		}
+		fmt.Println()
+		dhtquery.PrintTrace(os.Stdout, res.Trace)
+	}
+
+	sctx, scancel := context.WithTimeout(context.Background(), dhttracer.ShutdownTimeout)
+	defer scancel()
+	r, serr := t.Stop(sctx)
+	io.Copy(os.Stdout, r)
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+// isLoopback returns whether addr only listens on the loopback interface.
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func runCompare(cfg dhtnode.NodeCfg, opts Opts) error {
+	queries, err := readQueries(opts.QueriesFile)
+	if err != nil {
+		return err
+	}
+
+	var variants []dhttracer.Variant
+	for _, v := range strings.Split(opts.Compare, ";") {
+		p, err := dhtnode.ParseDhtParams(v)
+		if err == nil {
+			err = p.CheckPublic()
+		}
+		if err != nil {
+			return err
+		}
+		variants = append(variants, dhttracer.Variant{Name: p.String(), Params: p})
+	}
+
+	fmt.Printf("comparing %d variants over %d queries, %d rounds...\n",
+		len(variants), len(queries), opts.Rounds)
+	rs, err := dhttracer.Compare(context.Background(), dhttracer.CompareCfg{
+		Base:     cfg,
+		Variants: variants,
+		Queries:  queries,
+		Rounds:   opts.Rounds,
+		Settle:   time.Second * 5,
+		Timeout:  time.Minute,
+	})
+	if err != nil {
+		return err
+	}
+	dhttracer.PrintComparison(os.Stdout, rs)
+	return nil
+}
+
+// readQueries reads queries from a file, one per line. blank lines and
+// lines starting with # are skipped.
+func readQueries(file string) ([]dhttracer.Query, error) {
+	if file == "" {
+		return nil, errors.New("no queries. use --queries <file>")
+	}
+	buf, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var qs []dhttracer.Query
+	for _, line := range strings.Split(string(buf), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		q, err := dhttracer.ParseQuery(line)
+		if err != nil {
+			return nil, err
+		}
+		qs = append(qs, q)
+	}
+	return qs, nil
+}
+
+func readTraceFiles(files []string) ([]*dhtquery.Trace, error) {
+	if len(files) < 1 {
+		return nil, errors.New("no trace files to export")
+	}
+
+	var traces []*dhtquery.Trace
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		ts, err := dhtquery.ReadTraces(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		traces = append(traces, ts...)
+	}
+	return traces, nil
+}
+
+// exportTable writes the traces stored in files as a table to stdout.
+func exportTable(opts Opts, files []string) error {
+	traces, err := readTraceFiles(files)
+	if err != nil {
+		return err
+	}
+	t, err := tabular.NewTable(opts.Export, tabular.Select(traces, opts.ExportFilter.Filter))
+	if err != nil {
+		return err
+	}
+	return t.Write(os.Stdout, opts.ExportFormat)
+}
+
+// exportGraph writes the lookup graph of the one stored trace that matches
+// the filter to stdout.
+func exportGraph(opts Opts, files []string) error {
+	traces, err := readTraceFiles(files)
+	if err != nil {
+		return err
+	}
+	traces = tabular.Select(traces, opts.ExportFilter.Filter)
+	if len(traces) != 1 {
+		return fmt.Errorf("%d traces match, select one with --filter id=<query-id>", len(traces))
+	}
+
+	switch opts.Graph {
+	case "dot":
+		return dhtquery.WriteDOT(os.Stdout, traces[0])
+	case "svg":
+		return dhtquery.WriteSVG(os.Stdout, traces[0])
+	default:
+		return fmt.Errorf("unknown graph format: %v", opts.Graph)
+	}
+}
+
+// exportChromeTrace converts the traces stored in files into a Chrome trace
+// event file.
+func exportChromeTrace(out string, files []string) error {
+	traces, err := readTraceFiles(files)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := dhtquery.WriteChromeTrace(f, traces...); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %d traces to %s\n", len(traces), out)
+	return nil
+}
+
 func nodeCfgWithOpts(opts Opts) dhtnode.NodeCfg {
 	cfg := dhtnode.DefaultNodeCfg()
 	cfg.Bootstrap = opts.BootstrapAddrs
@@ -134,19 +638,31 @@ func nodeCfgWithOpts(opts Opts) dhtnode.NodeCfg {
 		cfg.Libp2pOpts = dhtnode.Libp2pOptionsQUIC()
 	}
 
-	// update alpha value
-	fmt.Fprintln(os.Stderr, "using dht concurrency (alpha) of", opts.KadAlpha)
-	cfg.DhtOpts = append(cfg.DhtOpts, dht.Concurrency(opts.KadAlpha))
+	// update dht params
+	fmt.Fprintln(os.Stderr, "using dht params", opts.DhtParams)
+	cfg.DhtOpts = append(cfg.DhtOpts, opts.DhtParams.Options()...)
+	fmt.Fprintln(os.Stderr, "using validators", opts.Validators)
+	cfg.Validators = opts.Validators
 
 	return cfg
 }
 
 func errMain() error {
-	opts, _, err := parseOpts()
+	opts, args, err := parseOpts()
 	if err != nil {
 		return err
 	}
 
+	if opts.ChromeTrace != "" {
+		return exportChromeTrace(opts.ChromeTrace, args)
+	}
+	if opts.Export != "" {
+		return exportTable(opts, args)
+	}
+	if opts.Graph != "" {
+		return exportGraph(opts, args)
+	}
+
 	// setup debug logging
 	if opts.Debug {
 		fmt.Fprintln(os.Stderr, "debug logging on")
@@ -157,14 +673,22 @@ func errMain() error {
 	// nodecfg
 	cfg := nodeCfgWithOpts(opts)
 
+	if opts.Compare != "" {
+		return runCompare(cfg, opts)
+	}
+
 	// setup tracer
-	t, err := setupTracer(cfg)
+	t, err := setupTracer(cfg, opts)
 	if err != nil {
 		return err
 	}
 
+	if len(args) > 0 {
+		return runCLIQuery(t, args)
+	}
+
 	// run tracer server
-	return runTracerServer(t, opts.ServerAddr)
+	return runTracerServer(t, opts)
 }
 
 func main() {